@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/avalonbits/echo-template-service/cmd/setup"
+	"github.com/avalonbits/echo-template-service/config"
+	"github.com/avalonbits/echo-template-service/storage"
+	"github.com/avalonbits/echo-template-service/storage/datastore"
+)
+
+func backupCmd(ctx context.Context, cfg config.Config, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: household backup <file>")
+	}
+
+	// A backup must not change the database it copies, so it is not migrated.
+	db, err := storage.GetDB(cfg.Database, datastore.Migrations, datastore.Factory, storage.SkipMigrations())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err := db.Backup(ctx, args[0]); err != nil {
+		return err
+	}
+	log.Printf("database backed up to %s", args[0])
+	return nil
+}
+
+func restoreCmd(ctx context.Context, cfg config.Config, args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	replica := fs.String("replica", "", "directory or s3://bucket/prefix URL of the replica to restore from")
+	at := fs.String("at", "", "point in time to restore to, in RFC3339 format")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	args = fs.Args()
+
+	if *replica != "" {
+		if len(args) != 0 {
+			return fmt.Errorf("usage: household restore -replica <dir or s3 URL> [-at <time>]")
+		}
+		t := time.Now()
+		if *at != "" {
+			var err error
+			if t, err = time.Parse(time.RFC3339, *at); err != nil {
+				return err
+			}
+		}
+		r, err := setup.OpenReplica(cfg, *replica)
+		if err != nil {
+			return err
+		}
+		if err := storage.RestoreReplica(ctx, r, cfg.Database, t); err != nil {
+			return err
+		}
+		log.Printf("database restored from %s as of %s", *replica, t.Format(time.RFC3339))
+		return nil
+	}
+
+	if len(args) != 1 || *at != "" {
+		return fmt.Errorf("usage: household restore <file>")
+	}
+
+	if err := storage.Restore(ctx, args[0], cfg.Database); err != nil {
+		return err
+	}
+	log.Printf("database restored from %s", args[0])
+	return nil
+}
@@ -2,13 +2,71 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/avalonbits/echo-template-service/cmd/setup"
 	"github.com/avalonbits/echo-template-service/config"
 )
 
+const usage = `usage: household [command]
+
+Commands:
+  serve            run the web server (default)
+  backup <file>    write a snapshot of the database to file
+  restore <file>   replace the database with the snapshot in file; stop the server first
+  restore -replica <dir> [-at <RFC3339 time>]
+                   rebuild the database from a replica as of a point in time (default: latest)
+  migrate <cmd>    manage the database schema; run "household migrate" for commands
+`
+
 func main() {
-	server := setup.Echo(config.Get(context.Background()))
-	defer server.Close()
-	server.Logger.Fatal(server.Start(":1323"))
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	cfg := config.Get(ctx)
+	args := os.Args[1:]
+	if len(args) == 0 {
+		args = []string{"serve"}
+	}
+
+	var err error
+	switch args[0] {
+	case "serve":
+		serve(ctx, cfg)
+	case "backup":
+		err = backupCmd(ctx, cfg, args[1:])
+	case "restore":
+		err = restoreCmd(ctx, cfg, args[1:])
+	case "migrate":
+		err = migrateCmd(ctx, cfg, args[1:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func serve(ctx context.Context, cfg config.Config) {
+	server := setup.Echo(cfg)
+	go func() {
+		if err := server.Start(":1323"); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			server.Logger.Fatal(err)
+		}
+	}()
+
+	<-ctx.Done()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		server.Logger.Fatal(err)
+	}
 }
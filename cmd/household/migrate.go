@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/avalonbits/echo-template-service/config"
+	"github.com/avalonbits/echo-template-service/storage"
+	"github.com/avalonbits/echo-template-service/storage/datastore"
+)
+
+const migrateUsage = `usage: household migrate <command>
+
+Commands:
+  status              show which migrations were applied
+  up                  apply all pending migrations
+  up-to <version>     apply pending migrations up to version
+  down                roll back the last migration
+  redo                roll back the last migration and apply it again
+  create <name>       add an empty migration for every dialect, run from the repository root
+`
+
+// migrationsDir is where migrate create writes new migrations, relative to the repository root.
+const migrationsDir = "storage/datastore/migrations"
+
+func migrateCmd(ctx context.Context, cfg config.Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	// Creating migrations only touches the source tree.
+	if args[0] == "create" {
+		if len(args) != 2 {
+			return fmt.Errorf("usage: household migrate create <name>")
+		}
+		created, err := storage.CreateMigration(migrationsDir, args[1], time.Now())
+		for _, path := range created {
+			log.Printf("created %s", path)
+		}
+		return err
+	}
+
+	m, err := storage.NewMigrator(cfg.Database, datastore.Migrations)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	switch args[0] {
+	case "status":
+		return m.Status(ctx)
+	case "up":
+		return m.UpTo(ctx, math.MaxInt64)
+	case "up-to":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: household migrate up-to <version>")
+		}
+		version, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid version %q: %w", args[1], err)
+		}
+		return m.UpTo(ctx, version)
+	case "down":
+		return m.Down(ctx)
+	case "redo":
+		return m.Redo(ctx)
+	default:
+		return errors.New(migrateUsage)
+	}
+}
@@ -1,22 +1,34 @@
 package setup
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
 	"log"
 	"net/http"
-	"strconv"
+	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
-	"github.com/alexedwards/scs/sqlite3store"
 	"github.com/alexedwards/scs/v2"
 	"github.com/avalonbits/echo-template-service/config"
 	"github.com/avalonbits/echo-template-service/embeded"
 	"github.com/avalonbits/echo-template-service/endpoints"
 	"github.com/avalonbits/echo-template-service/endpoints/web"
+	"github.com/avalonbits/echo-template-service/service/backup"
+	"github.com/avalonbits/echo-template-service/service/janitor"
+	"github.com/avalonbits/echo-template-service/service/notify"
 	"github.com/avalonbits/echo-template-service/service/recaptcha"
 	"github.com/avalonbits/echo-template-service/service/user"
 	"github.com/avalonbits/echo-template-service/storage"
 	"github.com/avalonbits/echo-template-service/storage/datastore"
+	"github.com/avalonbits/echo-template-service/storage/jobs"
+	"github.com/avalonbits/echo-template-service/storage/keyring"
+	"github.com/avalonbits/echo-template-service/storage/search"
+	"github.com/avalonbits/echo-template-service/storage/sessionstore"
+	"github.com/avalonbits/echo-template-service/storage/trash"
 	"github.com/honeycombio/otel-config-go/otelconfig"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
@@ -34,6 +46,17 @@ func Echo(cfg config.Config) Server {
 	server.Echo = e
 
 	templates := embeded.Templates()
+	templates.Funcs(template.FuncMap{"pattern": web.Pattern})
+	if dir := cfg.TemplateDir; dir != "" {
+		if err := templates.FromDir(dir); err != nil {
+			log.Fatalf("error reading templates from %s: %v", dir, err)
+		}
+	} else if endpoints.Domain(cfg.FullDomain()).IsDev() {
+		// Outside a working tree, fall back to the embedded templates.
+		if err := templates.FromDir("embeded"); err != nil {
+			log.Printf("using embedded templates: %v", err)
+		}
+	}
 	e.Renderer = templates
 	e.HTTPErrorHandler = web.ErrorHandler(templates)
 
@@ -50,11 +73,14 @@ func Echo(cfg config.Config) Server {
 		server.otelShutdown = otelShutdown
 		e.Use(otelecho.Middleware(cfg.ServiceName))
 	}
+
+	// The request ID correlates errors on pages with the logs when tracing is off.
+	e.Use(middleware.RequestID())
 	e.Use(middleware.BodyLimit("10k"))
 
 	// Setup CSRF protection.
 	e.Use(middleware.CSRFWithConfig(middleware.CSRFConfig{
-		TokenLookup:    "form:csrf_token",
+		TokenLookup:    "form:csrf_token,header:X-CSRF-Token",
 		CookieMaxAge:   int(1 * time.Hour / time.Second),
 		CookieHTTPOnly: true,
 		CookieSecure:   true,
@@ -64,7 +90,8 @@ func Echo(cfg config.Config) Server {
 		CookieSameSite: http.SameSiteStrictMode,
 		Skipper: func(c echo.Context) bool {
 			path := c.Request().URL.Path
-			return strings.HasPrefix(path, "/static") || path == "/payment_hook"
+			return strings.HasPrefix(path, "/static") || strings.HasPrefix(path, "/api/") ||
+				path == "/payment_hook"
 		},
 	}))
 
@@ -75,18 +102,14 @@ func Echo(cfg config.Config) Server {
 	}))
 
 	// Setup main DB and session handling.
-	db, err := storage.GetDB(
-		cfg.Database,
-		datastore.Migrations,
-		datastore.Factory,
-	)
+	db, err := openDB(cfg)
 	if err != nil {
 		log.Fatalf("error setting up database: %v", err)
 	}
 	server.db = db
 
 	sessionManager := scs.New()
-	sessionManager.Store = sqlite3store.New(db.RDBMS())
+	sessionManager.Store = sessionstore.New(db)
 	sessionManager.Lifetime = 24 * time.Hour * 7
 	sessionManager.IdleTimeout = 24 * time.Hour
 	sessionManager.Cookie.Name = "_s"
@@ -100,64 +123,194 @@ func Echo(cfg config.Config) Server {
 	// Setup handlers
 	tracer := otel.Tracer(cfg.ServiceName)
 	recaptcha := recaptcha.New(tracer, cfg.RecaptchaToken)
-	users := user.New(db)
+	runner := jobs.New(db)
+	server.jobs = runner
+	if _, err := janitor.New(db, runner, otel.Meter(cfg.ServiceName), cfg.JanitorInterval); err != nil {
+		log.Fatalf("error setting up janitor: %v", err)
+	}
+	if cfg.BackupDir != "" {
+		prefix := strings.TrimSuffix(filepath.Base(cfg.Database), filepath.Ext(cfg.Database))
+		_, err := backup.New(db, runner, cfg.BackupDir, prefix, cfg.BackupSchedule, cfg.BackupKeep)
+		if err != nil {
+			log.Fatalf("error setting up backups: %v", err)
+		}
+	}
+	keys, err := loadKeyring(cfg)
+	if err != nil {
+		log.Fatalf("error loading keyring: %v", err)
+	}
+	users, err := user.New(db, keys, runner)
+	if err != nil {
+		log.Fatalf("error setting up users: %v", err)
+	}
+
+	channels := []notify.Channel{}
+	if cfg.SMTPAddr != "" {
+		channels = append(channels, notify.NewEmail(
+			cfg.SMTPAddr, cfg.SMTPFrom, cfg.SMTPUsername, cfg.SMTPPassword))
+	}
+	channels = append(channels, notify.NewWebhook())
+	notifies := notify.New(db, keys, runner, channels...)
+	searches, err := search.New(context.Background(), db)
+	if err != nil {
+		log.Printf("full-text search disabled: %v", err)
+	}
+	trashes, err := trash.New(db, runner, cfg.TrashRetention, trash.People)
+	if err != nil {
+		log.Fatalf("error setting up trash: %v", err)
+	}
+
 	handlers := web.New(
 		endpoints.Domain(cfg.FullDomain()),
 		sessionManager,
 		users,
+		notifies,
+		searches,
+		trashes,
 		recaptcha,
+		storage.NewCursors(keys.Derive("cursors")),
 	)
-	e.Use(sessionDataMiddleware(sessionManager, users, cfg.RecaptchaToken != ""))
+	e.Use(sessionDataMiddleware(sessionManager, users, notifies, cfg.Admins, cfg.RecaptchaToken != ""))
 
 	// Setup endpoints.
+	templates.NewView("error_403", "base.tmpl", "error_403.tmpl", "menu.tmpl")
+	templates.NewView("error_404", "base.tmpl", "error_404.tmpl", "menu.tmpl")
+	templates.NewView("error_429", "base.tmpl", "error_429.tmpl", "menu.tmpl")
+	templates.NewView("error_500", "base.tmpl", "error_500.tmpl", "menu.tmpl")
+
 	templates.NewView("index", "base.tmpl", "menu.tmpl")
-	e.GET("/", web.PageRenderer("index"))
+	e.GET("/", web.PageRenderer(templates, "index"))
 
 	templates.NewView("signin_form", "base.tmpl", "signin_form.tmpl", "menu.tmpl")
-	e.GET("/form/signin", web.PageRenderer("signin_form"), signedOutMiddleware)
+	e.GET("/form/signin", web.PageRenderer(templates, "signin_form"), signedOutMiddleware)
 	e.POST("/form/signin", handlers.Signin, signedOutMiddleware)
 
 	templates.NewView("signup_form", "base.tmpl", "signup_form.tmpl", "menu.tmpl")
-	e.GET("/form/signup", web.PageRenderer("signup_form"), signedOutMiddleware)
+	e.GET("/form/signup", web.PageRenderer(templates, "signup_form"), signedOutMiddleware)
 	e.POST("/form/signup", handlers.Signup, signedOutMiddleware)
 	e.GET("/signout", handlers.Signout, signedInMiddleware)
 
-	// Setup static page serving.
+	templates.NewView("notifications", "base.tmpl", "notifications.tmpl", "pagination.tmpl", "menu.tmpl")
+	templates.Targets("notifications", "notifications")
+	e.GET("/notifications", handlers.Notifications, signedInMiddleware)
+	e.POST("/notifications/read", handlers.MarkNotificationsRead, signedInMiddleware)
+
+	templates.NewView("notification_prefs", "base.tmpl", "notification_prefs.tmpl", "menu.tmpl")
+	e.GET("/notifications/preferences", handlers.NotificationPreferences, signedInMiddleware)
+	e.POST("/notifications/preferences", handlers.SetNotificationPreferences, signedInMiddleware)
+
+	templates.NewView("search", "base.tmpl", "search.tmpl", "menu.tmpl")
+	e.GET("/search", handlers.Search, signedInMiddleware)
+
+	templates.NewView("account", "base.tmpl", "account.tmpl", "menu.tmpl")
+	e.GET("/account", handlers.Account, signedInMiddleware)
+	e.POST("/account/language", handlers.SetLanguage, signedInMiddleware)
+	e.POST("/account/delete", handlers.DeleteAccount, signedInMiddleware)
+
+	templates.NewView("trash", "base.tmpl", "trash.tmpl", "menu.tmpl")
+	e.GET("/trash", handlers.Trash, signedInMiddleware)
+	e.POST("/trash/restore", handlers.RestoreTrash, signedInMiddleware)
+
+	// Setup JSON API.
+	apiRoutes(e, handlers)
+	e.GET("/api/openapi.json", handlers.OpenAPI)
+	e.GET("/api/docs", func(c echo.Context) error {
+		return c.Redirect(http.StatusFound, "/static/api.html")
+	})
+
+	// Setup static page serving. Templates link to files with {{asset "name"}}, whose URLs are
+	// cached for good; see embeded.ServeStatic.
 	staticG := e.Group("static")
 	staticG.Use(middleware.Gzip())
-	staticG.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
-		return func(c echo.Context) error {
-			// Set cache control header to 1 year so we can cache for a long time any static file.
-			// This means if we need to update a static file, we need to change its name.
-			//
-			// WARNING: This will cache 4xx-5XX responses as well. We should instead write our own Static
-			// handler that caches only on success.
-			c.Response().Header().Set(
-				"Cache-Control",
-				"max-age="+strconv.Itoa(int(365*24*time.Hour/time.Second)),
-			)
-			return next(c)
-		}
-	})
-	staticG.StaticFS("/", embeded.Static())
+	staticG.GET("/*", embeded.ServeStatic)
+
+	// Every view, route, job handler and schedule is registered by now: check that the views
+	// handlers render exist and start processing jobs.
+	if missing := web.MissingViews(templates.Has); len(missing) > 0 {
+		log.Fatalf("views not registered: %v", missing)
+	}
+	if err := runner.Start(context.Background()); err != nil {
+		log.Fatalf("error starting job runner: %v", err)
+	}
 
 	return server
 }
 
+// apiRoutes registers the /api/v1 routes, which the OpenAPI spec must document; see CheckSpec.
+func apiRoutes(e *echo.Echo, handlers *web.Handler) {
+	api := e.Group("/api/v1", web.RequireJSON)
+	api.POST("/signin", handlers.APISignin, apiSignedOutMiddleware)
+	api.POST("/signup", handlers.APISignup, apiSignedOutMiddleware)
+	api.POST("/signout", handlers.APISignout, apiSignedInMiddleware)
+	api.GET("/me", handlers.APIMe, apiSignedInMiddleware)
+	api.GET("/notifications", handlers.APINotifications, apiSignedInMiddleware)
+}
+
 type Server struct {
 	*echo.Echo
 
 	db           *storage.DB[datastore.Queries]
+	jobs         *jobs.Runner
 	otelShutdown func()
 }
 
+// Shutdown stops accepting requests and waits for in-flight ones, then waits for running jobs
+// before closing the database. Whatever is still running when ctx is done gets cancelled.
+func (s Server) Shutdown(ctx context.Context) error {
+	err := s.Echo.Shutdown(ctx)
+	err = errors.Join(err, s.jobs.Shutdown(ctx))
+	s.Cleanup()
+	return errors.Join(err, s.db.Close())
+}
+
 func (s Server) Cleanup() {
-	s.otelShutdown()
+	if s.otelShutdown != nil {
+		s.otelShutdown()
+	}
+}
+
+// OpenReplica returns the replica target names, a directory or an s3://bucket/prefix URL.
+// openDB opens the database of cfg, migrating it unless cfg requires it to be migrated already.
+func openDB(cfg config.Config) (*storage.DB[datastore.Queries], error) {
+	opts := []storage.Option{
+		storage.WithMeter(otel.Meter(cfg.ServiceName)),
+		storage.WithTracer(otel.Tracer(cfg.ServiceName)),
+		storage.WithSlowQuery(cfg.SlowQueryThreshold),
+	}
+	if cfg.RequireMigrated {
+		opts = append(opts, storage.RequireMigrated())
+	}
+	if cfg.Replica != "" {
+		replica, err := OpenReplica(cfg, cfg.Replica)
+		if err != nil {
+			return nil, fmt.Errorf("error setting up replica: %w", err)
+		}
+		opts = append(opts, storage.WithReplica(replica, cfg.ReplicaInterval, cfg.ReplicaRetention))
+	}
+	return storage.GetDB(cfg.Database, datastore.Migrations, datastore.Factory, opts...)
+}
+
+func OpenReplica(cfg config.Config, target string) (storage.Replica, error) {
+	return storage.OpenReplica(target, storage.S3Options{
+		Endpoint:  cfg.ReplicaS3Endpoint,
+		Region:    cfg.ReplicaS3Region,
+		AccessKey: cfg.ReplicaS3AccessKey,
+		SecretKey: cfg.ReplicaS3SecretKey,
+	})
+}
+
+func loadKeyring(cfg config.Config) (*keyring.Keyring, error) {
+	if cfg.KeyringFile != "" {
+		return keyring.LoadFile(cfg.KeyringFile)
+	}
+	return keyring.Load([]byte(cfg.Keyring))
 }
 
 func sessionDataMiddleware(
 	sessionManager *scs.SessionManager,
 	users *user.Service,
+	notifies *notify.Service,
+	admins []string,
 	recaptchaOn bool,
 ) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
@@ -172,18 +325,46 @@ func sessionDataMiddleware(
 				Recaptcha: recaptchaOn,
 			}
 			ctx := req.Context()
+			sessionData.LoadFlashes(sessionManager, ctx)
+			var preferred string
 			uid := sessionManager.GetString(ctx, "uid")
 			if uid != "" {
 				person, err := users.GetUser(ctx, uid)
-				if err != nil {
+				switch {
+				case storage.NoRows(err):
+					// The account was deleted from another session: carry on signed out.
+					sessionManager.Remove(ctx, "uid")
+				case err != nil:
 					// Instead, need to clear the session/cookie and redirect to signin.
 					panic(err)
+				default:
+					sessionData.Email = person.Email
+					sessionData.Name = person.Name
+					sessionData.InternalUID = person.ID
+					sessionData.Handle = person.Handle
+					sessionData.Admin = slices.Contains(admins, person.ID)
+					preferred = person.Language
+
+					sessionData.Unread, err = notifies.Unread(ctx, uid)
+					if err != nil {
+						return err
+					}
 				}
-				sessionData.Email = person.Email
-				sessionData.Name = person.Name
-				sessionData.InternalUID = person.ID
-				sessionData.Handle = person.Handle
 			}
+
+			// A ?lang= choice sticks for the session, but the language a person picked in their
+			// account wins over it on later requests.
+			if q := c.QueryParam("lang"); q != "" {
+				sessionManager.Put(ctx, "lang", embeded.MatchLanguage(q))
+			}
+			sessionData.Lang = embeded.MatchLanguage(
+				c.QueryParam("lang"),
+				preferred,
+				sessionManager.GetString(ctx, "lang"),
+				req.Header.Get("Accept-Language"),
+			)
+			embeded.SetLang(c, sessionData.Lang)
+
 			tk, ok := c.Get("csc").(string)
 			if ok {
 				sessionData.CSRFToken = tk
@@ -214,3 +395,23 @@ func signedOutMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 		return next(c)
 	}
 }
+
+func apiSignedInMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		sess, _ := c.Get("sessionData").(web.SessionData)
+		if !sess.SignedIn() {
+			return echo.NewHTTPError(http.StatusUnauthorized, "error.sign_in_first")
+		}
+		return next(c)
+	}
+}
+
+func apiSignedOutMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		sess, _ := c.Get("sessionData").(web.SessionData)
+		if sess.SignedIn() {
+			return echo.NewHTTPError(http.StatusConflict, "error.signed_in")
+		}
+		return next(c)
+	}
+}
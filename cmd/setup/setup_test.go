@@ -0,0 +1,44 @@
+package setup
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/avalonbits/echo-template-service/config"
+	"github.com/avalonbits/echo-template-service/endpoints/web"
+	"github.com/avalonbits/echo-template-service/storage"
+	"github.com/labstack/echo/v4"
+)
+
+func TestSpecDocumentsAPIRoutes(t *testing.T) {
+	e := echo.New()
+	apiRoutes(e, &web.Handler{})
+	for _, problem := range web.CheckSpec(e.Routes()) {
+		t.Error(problem)
+	}
+}
+
+func TestRequireMigratedRefusesPendingMigrations(t *testing.T) {
+	cfg := config.Config{Database: filepath.Join(t.TempDir(), "test.db"), RequireMigrated: true}
+	if db, err := openDB(cfg); !errors.Is(err, storage.ErrPendingMigrations) {
+		if err == nil {
+			db.Close()
+		}
+		t.Fatalf("openDB with pending migrations = %v, want ErrPendingMigrations", err)
+	}
+
+	cfg.RequireMigrated = false
+	db, err := openDB(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	cfg.RequireMigrated = true
+	db, err = openDB(cfg)
+	if err != nil {
+		t.Fatalf("openDB once migrated = %v", err)
+	}
+	db.Close()
+}
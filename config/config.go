@@ -4,6 +4,7 @@ import (
 	"context"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/sethvargo/go-envconfig"
 )
@@ -24,6 +25,49 @@ type Config struct {
 	ServiceName    string `env:"OTEL_SERVICE_NAME"`
 	BindAddress    string `env:"BIND_ADDRESS"`
 	RecaptchaToken string `env:"RECAPTCHA_TOKEN"`
+	SMTPAddr       string `env:"SMTP_ADDR"`
+	SMTPUsername   string `env:"SMTP_USERNAME"`
+	SMTPPassword   string `env:"SMTP_PASSWORD"`
+	SMTPFrom       string `env:"SMTP_FROM"`
+
+	// Keyring is the JSON keyring sealing sensitive columns, or KeyringFile the path to it.
+	// Exactly one of them is required.
+	Keyring     string `env:"KEYRING"`
+	KeyringFile string `env:"KEYRING_FILE"`
+
+	// TemplateDir is the embeded directory of a working tree to read templates from, re-parsing
+	// them when they change. It defaults to "embeded" for localhost domains.
+	TemplateDir string `env:"TEMPLATE_DIR"`
+
+	// Admins are the IDs of the people allowed to search everyone and restore any account. IDs never
+	// change nor get reused, unlike handles, which anyone can sign up with once they are free.
+	Admins []string `env:"ADMINS"`
+
+	// RequireMigrated refuses to start the server when migrations are pending instead of applying
+	// them.
+	RequireMigrated bool `env:"REQUIRE_MIGRATED"`
+
+	// SlowQueryThreshold is how long a query can take before it is logged as slow.
+	SlowQueryThreshold time.Duration `env:"SLOW_QUERY_THRESHOLD"`
+
+	JanitorInterval time.Duration `env:"JANITOR_INTERVAL"`
+
+	// TrashRetention is how long deleted records can be restored before they are purged.
+	TrashRetention time.Duration `env:"TRASH_RETENTION"`
+
+	BackupDir      string `env:"BACKUP_DIR"`
+	BackupSchedule string `env:"BACKUP_SCHEDULE"`
+	BackupKeep     int    `env:"BACKUP_KEEP"`
+
+	// Replica is a directory or an s3://bucket/prefix URL to replicate the database to. S3 replicas
+	// use the ReplicaS3 settings.
+	Replica            string        `env:"REPLICA"`
+	ReplicaInterval    time.Duration `env:"REPLICA_INTERVAL"`
+	ReplicaRetention   time.Duration `env:"REPLICA_RETENTION"`
+	ReplicaS3Endpoint  string        `env:"REPLICA_S3_ENDPOINT"`
+	ReplicaS3Region    string        `env:"REPLICA_S3_REGION"`
+	ReplicaS3AccessKey string        `env:"REPLICA_S3_ACCESS_KEY"`
+	ReplicaS3SecretKey string        `env:"REPLICA_S3_SECRET_KEY"`
 }
 
 func (c Config) AppURL() string {
@@ -47,6 +91,10 @@ func validate(cfg *Config) Config {
 		panic("required value for Database")
 	}
 
+	if (cfg.Keyring == "") == (cfg.KeyringFile == "") {
+		panic("required value for exactly one of Keyring or KeyringFile")
+	}
+
 	if cfg.Port == "" {
 		cfg.Port = "9001"
 	} else if _, err := strconv.ParseInt(cfg.Port, 10, 16); err != nil {
@@ -57,5 +105,47 @@ func validate(cfg *Config) Config {
 		cfg.BindAddress = "localhost"
 	}
 
+	if cfg.SlowQueryThreshold == 0 {
+		cfg.SlowQueryThreshold = 200 * time.Millisecond
+	} else if cfg.SlowQueryThreshold < 0 {
+		panic("invalid slow query threshold: " + cfg.SlowQueryThreshold.String())
+	}
+
+	if cfg.JanitorInterval == 0 {
+		cfg.JanitorInterval = time.Hour
+	} else if cfg.JanitorInterval < time.Minute {
+		panic("janitor interval must be at least 1m: " + cfg.JanitorInterval.String())
+	}
+
+	if cfg.TrashRetention == 0 {
+		cfg.TrashRetention = 30 * 24 * time.Hour
+	} else if cfg.TrashRetention < 0 {
+		panic("invalid trash retention: " + cfg.TrashRetention.String())
+	}
+
+	if cfg.BackupSchedule == "" {
+		cfg.BackupSchedule = "@daily"
+	}
+	if cfg.BackupKeep == 0 {
+		cfg.BackupKeep = 7
+	} else if cfg.BackupKeep < 0 {
+		panic("invalid number of backups to keep: " + strconv.Itoa(cfg.BackupKeep))
+	}
+
+	if cfg.ReplicaInterval == 0 {
+		cfg.ReplicaInterval = time.Second
+	} else if cfg.ReplicaInterval < 0 {
+		panic("invalid replica interval: " + cfg.ReplicaInterval.String())
+	}
+	if cfg.ReplicaRetention == 0 {
+		cfg.ReplicaRetention = 72 * time.Hour
+	} else if cfg.ReplicaRetention < 0 {
+		panic("invalid replica retention: " + cfg.ReplicaRetention.String())
+	}
+
+	if cfg.SMTPAddr != "" && cfg.SMTPFrom == "" {
+		panic("required value for SMTPFrom when SMTPAddr is set")
+	}
+
 	return *cfg
 }
@@ -0,0 +1,119 @@
+package embeded
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// asset is a file in static, fingerprinted by its content.
+type asset struct {
+	name   string
+	hashed string
+	etag   string
+	data   []byte
+}
+
+// assetManifest has the files in static by name, and by the hashed name pages link to. They are
+// read and hashed once, so the server always serves the content the hashes were computed from.
+type assetManifest struct {
+	byName   map[string]*asset
+	byHashed map[string]*asset
+}
+
+var assets = loadAssets()
+
+// hashLen is the number of hex digits of the content hash in hashed names.
+const hashLen = 16
+
+func loadAssets() *assetManifest {
+	m := &assetManifest{
+		byName:   map[string]*asset{},
+		byHashed: map[string]*asset{},
+	}
+	err := fs.WalkDir(static, "static", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := static.ReadFile(p)
+		if err != nil {
+			return err
+		}
+
+		sum := sha256.Sum256(data)
+		hash := hex.EncodeToString(sum[:])[:hashLen]
+		name := strings.TrimPrefix(p, "static/")
+		ext := path.Ext(name)
+		a := &asset{
+			name:   name,
+			hashed: strings.TrimSuffix(name, ext) + "." + hash + ext,
+			etag:   strconv.Quote(hash),
+			data:   data,
+		}
+		m.byName[a.name] = a
+		m.byHashed[a.hashed] = a
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// AssetURL returns the URL of the file name in static, with a hash of its content in the name so
+// it changes whenever the file does. Templates call it as {{asset "pico.min.css"}}.
+func AssetURL(name string) (string, error) {
+	a, ok := assets.byName[name]
+	if !ok {
+		return "", fmt.Errorf("unknown asset %q", name)
+	}
+	return "/static/" + a.hashed, nil
+}
+
+// ServeStatic serves the files in static under the * path parameter. Hashed names never change
+// content, so they are cached for a year. Plain names, and hashed names of older versions of a
+// file, are served with an ETag of their content so browsers revalidate them. There is no
+// Last-Modified: embedded files have no modification time. Errors are left to the error handler,
+// which sets no caching headers.
+func ServeStatic(c echo.Context) error {
+	name := c.Param("*")
+	header := c.Response().Header()
+
+	a, ok := assets.byHashed[name]
+	if ok {
+		header.Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		if a, ok = assets.byName[unhash(name)]; !ok {
+			return echo.ErrNotFound
+		}
+		header.Set("Cache-Control", "no-cache")
+	}
+	header.Set("ETag", a.etag)
+
+	// A zero modification time leaves out Last-Modified and If-Modified-Since.
+	http.ServeContent(c.Response(), c.Request(), a.name, time.Time{}, bytes.NewReader(a.data))
+	return nil
+}
+
+// unhash returns name without its content hash, or name if it has none.
+func unhash(name string) string {
+	ext := path.Ext(name)
+	rest, hash := path.Split(strings.TrimSuffix(name, ext))
+	i := strings.LastIndexByte(hash, '.')
+	if i < 0 || len(hash)-i-1 != hashLen {
+		return name
+	}
+	if _, err := hex.DecodeString(hash[i+1:]); err != nil {
+		return name
+	}
+	return rest + hash[:i] + ext
+}
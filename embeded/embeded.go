@@ -1,46 +1,159 @@
 package embeded
 
 import (
+	"bytes"
 	"embed"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
 	"io/fs"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/labstack/echo/v4"
 )
 
+// static has the files served by ServeStatic.
+//
 //go:embed static/*
 var static embed.FS
 
-func Static() fs.FS {
-	staticFS, err := fs.Sub(static, "static")
-	if err != nil {
-		panic(err)
-	}
-	return staticFS
-}
-
 //go:embed layouts partials
 var templateFiles embed.FS
 
 type Template struct {
 	templates *template.Template
-	views     map[string]*template.Template
+	views     map[string]*view
+	funcs     template.FuncMap
+
+	// files are where views are parsed from. When reload is set they are re-parsed whenever one
+	// of their files changes.
+	files  fs.FS
+	reload bool
+	mu     sync.Mutex
 }
 
-func (t *Template) Render(w io.Writer, vName string, data any, c echo.Context) error {
-	view, ok := t.views[vName]
-	if !ok {
-		panic(fmt.Sprintf("invalid view name:: %q", vName))
+// view is parsed once per language, with T translating to that language.
+type view struct {
+	base  string
+	files []string
+	// targets are the blocks htmx requests can ask for with HX-Target.
+	targets []string
+	tmpls   map[string]*template.Template
+	modTime time.Time
+}
+
+// Funcs adds funcs to the functions of views registered after it.
+func (t *Template) Funcs(funcs template.FuncMap) {
+	for name, fn := range funcs {
+		t.funcs[name] = fn
 	}
-	err := view.Execute(w, data)
-	if err != nil {
-		panic(err)
+}
+
+// FromDir makes views registered after it read their files from dir, the embeded directory of a
+// working tree, and re-parse them when they change. It is meant for development: production
+// keeps the templates embedded in the binary and parsed once.
+func (t *Template) FromDir(dir string) error {
+	files := os.DirFS(dir)
+	for _, sub := range []string{"layouts", "partials"} {
+		if _, err := fs.Stat(files, sub); err != nil {
+			return err
+		}
 	}
+	t.files = files
+	t.reload = true
 	return nil
 }
 
+var ErrUnknownView = errors.New("unknown view")
+
+var buffers = sync.Pool{
+	New: func() any { return new(bytes.Buffer) },
+}
+
+// Render renders the view vName. For htmx requests it renders only the block named by the
+// HX-Target header if it is one of the view's Targets, or else the content block, followed by the
+// blocks added with OOB.
+//
+// Nothing is written to w unless the whole view renders.
+func (t *Template) Render(w io.Writer, vName string, data any, c echo.Context) error {
+	view, err := t.lookup(vName, Lang(c))
+	if err != nil {
+		return err
+	}
+
+	buf := buffers.Get().(*bytes.Buffer)
+	defer buffers.Put(buf)
+	buf.Reset()
+
+	if !IsHTMX(c) {
+		if err := view.Execute(buf, data); err != nil {
+			return fmt.Errorf("rendering view %q: %w", vName, err)
+		}
+	} else {
+		vary(c.Response().Header(), "HX-Target")
+		block := "content"
+		if target := c.Request().Header.Get("HX-Target"); slices.Contains(t.views[vName].targets, target) {
+			block = target
+		}
+		oob, _ := c.Get(oobKey).([]string)
+		for _, name := range append([]string{block}, oob...) {
+			if err := view.ExecuteTemplate(buf, name, data); err != nil {
+				return fmt.Errorf("rendering block %q of view %q: %w", name, vName, err)
+			}
+		}
+	}
+	_, err = buf.WriteTo(w)
+	return err
+}
+
+// Has reports whether the view vName is registered.
+func (t *Template) Has(vName string) bool {
+	_, ok := t.views[vName]
+	return ok
+}
+
+const oobKey = "htmx.oob"
+
+// IsHTMX reports whether c is a request htmx made to swap part of a page. Boosted requests are
+// not: they swap the whole body, so they get the whole page. The response then depends on the
+// htmx headers, so IsHTMX adds them to its Vary header for caches.
+func IsHTMX(c echo.Context) bool {
+	if c == nil {
+		return false
+	}
+	vary(c.Response().Header(), "HX-Request", "HX-Boosted")
+	h := c.Request().Header
+	return h.Get("HX-Request") == "true" && h.Get("HX-Boosted") != "true"
+}
+
+// vary adds names to the Vary header unless it already has them.
+func vary(header http.Header, names ...string) {
+	var present []string
+	for _, v := range header.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			present = append(present, strings.ToLower(strings.TrimSpace(name)))
+		}
+	}
+	for _, name := range names {
+		if !slices.Contains(present, strings.ToLower(name)) {
+			header.Add("Vary", name)
+		}
+	}
+}
+
+// OOB adds the blocks names to the response of an htmx request, for out-of-band swaps. The root
+// element of each block needs an id and an hx-swap-oob attribute.
+func OOB(c echo.Context, names ...string) {
+	oob, _ := c.Get(oobKey).([]string)
+	c.Set(oobKey, append(oob, names...))
+}
+
 func (t *Template) NewView(name, base string, partials ...string) {
 	if _, ok := t.views[name]; ok {
 		panic(fmt.Errorf("view with name %q already registered.", name))
@@ -52,20 +165,143 @@ func (t *Template) NewView(name, base string, partials ...string) {
 		all[i+1] = fmt.Sprintf("partials/%s", p)
 	}
 
-	view := template.Must(template.New(base).Funcs(
-		template.FuncMap{
-			"safeHTML": safeHTML,
-		},
-	).ParseFS(templateFiles, all...))
-	t.views[name] = view
+	v := &view{base: base, files: all}
+	if err := t.parse(v); err != nil {
+		panic(err)
+	}
+	t.views[name] = v
+}
+
+// Targets lets htmx requests whose HX-Target is one of blocks render just that block of the view
+// name. Requests can't pick other blocks, which may be meant only for the full page.
+func (t *Template) Targets(name string, blocks ...string) {
+	v, ok := t.views[name]
+	if !ok {
+		panic(fmt.Errorf("%w: %q", ErrUnknownView, name))
+	}
+	for _, block := range blocks {
+		if v.tmpl(DefaultLang).Lookup(block) == nil {
+			panic(fmt.Errorf("view %q has no block %q", name, block))
+		}
+	}
+	v.targets = append(v.targets, blocks...)
+}
+
+func (t *Template) lookup(vName, lang string) (*template.Template, error) {
+	v, ok := t.views[vName]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownView, vName)
+	}
+	if !t.reload {
+		return v.tmpl(lang), nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if modTime, err := t.modTime(v); err != nil || modTime.After(v.modTime) {
+		if err := t.parse(v); err != nil {
+			return nil, fmt.Errorf("parsing view %q: %w", vName, err)
+		}
+	}
+	return v.tmpl(lang), nil
+}
+
+func (v *view) tmpl(lang string) *template.Template {
+	if tmpl, ok := v.tmpls[lang]; ok {
+		return tmpl
+	}
+	return v.tmpls[DefaultLang]
+}
+
+func (t *Template) parse(v *view) error {
+	modTime, err := t.modTime(v)
+	if err != nil {
+		return err
+	}
+
+	tmpls := map[string]*template.Template{}
+	for lang, c := range catalogs {
+		tmpl, err := template.New(v.base).Funcs(t.funcs).Funcs(template.FuncMap{
+			"T":    c.T,
+			"lang": c.Lang,
+		}).ParseFS(t.files, v.files...)
+		if err != nil {
+			return err
+		}
+		tmpls[lang] = tmpl
+	}
+	v.tmpls = tmpls
+	v.modTime = modTime
+	return nil
+}
+
+// modTime returns when the files of v last changed.
+func (t *Template) modTime(v *view) (time.Time, error) {
+	var latest time.Time
+	for _, name := range v.files {
+		info, err := fs.Stat(t.files, name)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
 }
 
 func Templates() *Template {
 	return &Template{
-		views: map[string]*template.Template{},
+		views: map[string]*view{},
+		funcs: template.FuncMap{
+			"languages": Languages,
+			"asset":     AssetURL,
+		},
+		files: templateFiles,
 	}
 }
 
-func safeHTML(str string) template.HTML {
-	return template.HTML(str)
+var errorPage = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html lang="{{.Lang}}">
+<head>
+    <meta charset="utf-8" />
+    <meta name="viewport" content="width=device-width, initial-scale=1" />
+    <link rel="stylesheet" href="{{.CSS}}">
+    <title>{{.Code}} {{.Status}}</title>
+</head>
+<body>
+    <main class="container">
+        <h1>{{.Status}}</h1>
+        <p>{{.Msg}}</p>
+        {{if .ID}}<p><small>{{.IDLabel}} <code>{{.ID}}</code></small></p>{{end}}
+        <p><a href="/">{{.Home}}</a></p>
+    </main>
+</body>
+</html>
+`))
+
+// ErrorPage is a page for the error code with msg and the ID of the error in the logs, that does
+// not depend on any view, for when the view showing the error fails to render. Its text comes
+// from the catalog of lang, or of DefaultLang if lang has none.
+func ErrorPage(lang string, code int, msg, id string) []byte {
+	c := CatalogFor(lang)
+	key := fmt.Sprintf("error.%d.title", min(code, http.StatusInternalServerError))
+	status := c.T(key)
+	if status == key {
+		status = http.StatusText(code)
+	}
+
+	css, _ := AssetURL("pico.min.css")
+	buf := bytes.Buffer{}
+	errorPage.Execute(&buf, map[string]any{
+		"Lang":    c.Lang(),
+		"Code":    code,
+		"Status":  status,
+		"Msg":     msg,
+		"ID":      id,
+		"IDLabel": c.T("error.id"),
+		"Home":    c.T("error.home"),
+		"CSS":     css,
+	})
+	return buf.Bytes()
 }
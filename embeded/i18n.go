@@ -0,0 +1,274 @@
+package embeded
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// locales has a message catalog per language, named by its BCP 47 tag. A message is a string or,
+// when it depends on a count, an object with a form per plural category:
+//
+//	{"signin.title": "Sign in", "notifications.unread": {"one": "%d unread", "other": "%d unread"}}
+//
+//go:embed locales
+var localeFiles embed.FS
+
+// DefaultLang is the language of people whose preferences match no catalog. Every other catalog
+// must have all of its messages.
+const DefaultLang = "en"
+
+// Catalog has the messages of a language.
+type Catalog struct {
+	lang   string
+	plural func(n int64) string
+	msgs   map[string]message
+}
+
+// message has a form per plural category. Messages without a count only have "other".
+type message map[string]string
+
+func (m *message) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*m = message{"other": s}
+		return nil
+	}
+
+	var forms map[string]string
+	if err := json.Unmarshal(data, &forms); err != nil {
+		return err
+	}
+	if forms["other"] == "" {
+		return errors.New("plural message without an other form")
+	}
+	*m = forms
+	return nil
+}
+
+// plurals are the CLDR plural rules of each language, for the categories its catalog uses.
+var plurals = map[string]func(n int64) string{
+	"en": func(n int64) string {
+		if n == 1 {
+			return "one"
+		}
+		return "other"
+	},
+	"pt-BR": func(n int64) string {
+		if n == 0 || n == 1 {
+			return "one"
+		}
+		return "other"
+	},
+}
+
+var catalogs = loadCatalogs()
+
+func loadCatalogs() map[string]*Catalog {
+	entries, err := fs.ReadDir(localeFiles, "locales")
+	if err != nil {
+		panic(err)
+	}
+
+	all := map[string]*Catalog{}
+	for _, entry := range entries {
+		lang, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok {
+			continue
+		}
+		plural, ok := plurals[lang]
+		if !ok {
+			panic(fmt.Sprintf("no plural rule for language %q", lang))
+		}
+		data, err := localeFiles.ReadFile("locales/" + entry.Name())
+		if err != nil {
+			panic(err)
+		}
+
+		c := &Catalog{lang: lang, plural: plural}
+		if err := json.Unmarshal(data, &c.msgs); err != nil {
+			panic(fmt.Errorf("invalid catalog %s: %w", entry.Name(), err))
+		}
+		all[lang] = c
+	}
+
+	def, ok := all[DefaultLang]
+	if !ok {
+		panic(fmt.Sprintf("missing catalog for the default language %q", DefaultLang))
+	}
+	for lang, c := range all {
+		for key := range def.msgs {
+			if _, ok := c.msgs[key]; !ok {
+				panic(fmt.Sprintf("catalog %s is missing message %q", lang, key))
+			}
+		}
+	}
+	return all
+}
+
+// CatalogFor returns the catalog of lang, or of DefaultLang if there is none.
+func CatalogFor(lang string) *Catalog {
+	if c, ok := catalogs[lang]; ok {
+		return c
+	}
+	return catalogs[DefaultLang]
+}
+
+func (c *Catalog) Lang() string {
+	return c.lang
+}
+
+// T returns the message key formatted with args. When the message has plural forms, the first
+// argument is the count that picks one. Keys that are not in the catalog are returned as they
+// are, so text that is not a key, like the message of an internal error, shows unchanged.
+func (c *Catalog) T(key string, args ...any) string {
+	m, ok := c.msgs[key]
+	if !ok {
+		return key
+	}
+
+	form := m["other"]
+	if len(args) > 0 {
+		if n, ok := count(args[0]); ok {
+			if f, ok := m[c.plural(n)]; ok {
+				form = f
+			}
+		}
+	}
+	if len(args) == 0 {
+		return form
+	}
+	return fmt.Sprintf(form, args...)
+}
+
+func count(arg any) (int64, bool) {
+	switch n := arg.(type) {
+	case int:
+		return int64(n), true
+	case int64:
+		return n, true
+	case int32:
+		return int64(n), true
+	}
+	return 0, false
+}
+
+// Language is a language with a catalog, with its name in that language.
+type Language struct {
+	Tag  string
+	Name string
+}
+
+// Languages returns the languages with a catalog, by tag.
+func Languages() []Language {
+	langs := make([]Language, 0, len(catalogs))
+	for tag, c := range catalogs {
+		langs = append(langs, Language{Tag: tag, Name: c.T("language.name")})
+	}
+	slices.SortFunc(langs, func(a, b Language) int { return strings.Compare(a.Tag, b.Tag) })
+	return langs
+}
+
+// MatchLanguage returns the language with a catalog that best matches the first preference that
+// matches any, or DefaultLang. A preference is a language tag or an Accept-Language header.
+func MatchLanguage(prefs ...string) string {
+	for _, pref := range prefs {
+		for _, tag := range parseAcceptLanguage(pref) {
+			if lang, ok := matchTag(tag); ok {
+				return lang
+			}
+		}
+	}
+	return DefaultLang
+}
+
+// matchTag matches tag exactly, then by its base language: "pt" and "pt-PT" match "pt-BR" when
+// that is the only Portuguese catalog.
+func matchTag(tag string) (string, bool) {
+	for lang := range catalogs {
+		if strings.EqualFold(lang, tag) {
+			return lang, true
+		}
+	}
+
+	base, _, _ := strings.Cut(tag, "-")
+	var matches []string
+	for lang := range catalogs {
+		if lb, _, _ := strings.Cut(lang, "-"); strings.EqualFold(lb, base) {
+			matches = append(matches, lang)
+		}
+	}
+	if len(matches) == 0 {
+		return "", false
+	}
+	slices.Sort(matches)
+	return matches[0], true
+}
+
+// parseAcceptLanguage returns the tags of an Accept-Language header by decreasing quality.
+func parseAcceptLanguage(header string) []string {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+
+	var tags []weighted
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.TrimSpace(tag)
+		if tag == "" || tag == "*" {
+			continue
+		}
+
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q > 0 {
+			tags = append(tags, weighted{tag: tag, q: q})
+		}
+	}
+	slices.SortStableFunc(tags, func(a, b weighted) int {
+		switch {
+		case a.q > b.q:
+			return -1
+		case a.q < b.q:
+			return 1
+		}
+		return 0
+	})
+
+	result := make([]string, len(tags))
+	for i, t := range tags {
+		result[i] = t.tag
+	}
+	return result
+}
+
+const langKey = "lang"
+
+// SetLang sets the language of the response to c.
+func SetLang(c echo.Context, lang string) {
+	c.Set(langKey, lang)
+}
+
+// Lang returns the language of the response to c, DefaultLang unless SetLang changed it.
+func Lang(c echo.Context) string {
+	if c == nil {
+		return DefaultLang
+	}
+	if lang, ok := c.Get(langKey).(string); ok {
+		return lang
+	}
+	return DefaultLang
+}
@@ -0,0 +1,191 @@
+package web
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/avalonbits/echo-template-service/service/notify"
+	"github.com/avalonbits/echo-template-service/service/user"
+	"github.com/labstack/echo/v4"
+)
+
+// The /api/v1 endpoints speak JSON and share the request types, validation and services of the
+// HTML endpoints. They use the same session cookie, so instead of a CSRF token they require a
+// JSON body, which browsers only send cross-origin after a CORS preflight.
+
+const mimeProblemJSON = "application/problem+json"
+
+// Problem is an RFC 7807 error response. Errors maps invalid request fields to what is wrong with
+// them.
+type Problem struct {
+	Type   string            `json:"type"`
+	Title  string            `json:"title"`
+	Status int               `json:"status"`
+	Detail string            `json:"detail,omitempty"`
+	Errors map[string]string `json:"errors,omitempty"`
+	// ID identifies an internal error in the logs and traces.
+	ID string `json:"id,omitempty"`
+}
+
+// wantsJSON reports whether errors should be problem documents instead of HTML pages: always for
+// the API, and for other requests that prefer JSON.
+func wantsJSON(c echo.Context) bool {
+	req := c.Request()
+	if strings.HasPrefix(req.URL.Path, "/api/") {
+		return true
+	}
+	accept, _, _ := strings.Cut(req.Header.Get(echo.HeaderAccept), ",")
+	mediaType, _, _ := mime.ParseMediaType(strings.TrimSpace(accept))
+	return mediaType == echo.MIMEApplicationJSON || mediaType == mimeProblemJSON
+}
+
+func problem(c echo.Context, code int, detail string, errs map[string]string, id string) error {
+	c.Response().Header().Set(echo.HeaderContentType, mimeProblemJSON)
+	return c.JSON(code, Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(code),
+		Status: code,
+		Detail: detail,
+		Errors: errs,
+		ID:     id,
+	})
+}
+
+// RequireJSON rejects API requests that change state without a JSON body.
+func RequireJSON(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		switch c.Request().Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			return next(c)
+		}
+		if !boundJSON(c) {
+			return echo.NewHTTPError(http.StatusUnsupportedMediaType, "error.json_body")
+		}
+		return next(c)
+	}
+}
+
+// boundJSON reports whether the body of c is JSON, which Bind decodes by json tags.
+func boundJSON(c echo.Context) bool {
+	mediaType, _, _ := mime.ParseMediaType(c.Request().Header.Get(echo.HeaderContentType))
+	return mediaType == echo.MIMEApplicationJSON
+}
+
+type personJSON struct {
+	ID     string `json:"id"`
+	Handle string `json:"handle"`
+	Name   string `json:"name,omitempty"`
+	Email  string `json:"email,omitempty"`
+}
+
+func toPersonJSON(p user.Person) personJSON {
+	return personJSON{ID: p.ID, Handle: p.Handle, Name: p.Name, Email: p.Email}
+}
+
+func (h *Handler) APISignin(c echo.Context) error {
+	r := signinRequest{}
+	if err := h.validateRequest(c, &r); err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+	p, err := h.users.Signin(ctx, r.Username, r.Password)
+	if err != nil {
+		return h.userErr("index", err)
+	}
+
+	h.sess.Put(ctx, "uid", p.ID)
+	h.notify(c, p.ID, notify.EventSignin, p.Handle, c.RealIP())
+	return c.JSON(http.StatusOK, toPersonJSON(p))
+}
+
+func (h *Handler) APISignup(c echo.Context) error {
+	r := signupRequest{}
+	if err := h.validateRequest(c, &r); err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+	if err := h.recaptcha.Verify(ctx, r.Recaptcha); err != nil {
+		return h.errMsg(http.StatusBadRequest, "error.recaptcha")
+	}
+
+	uid, err := h.users.Signup(ctx, r.Username, r.Password)
+	if errors.Is(err, user.ErrHandleTaken) {
+		return h.formErr(c, http.StatusConflict, "index", &r, FieldErrors{"username": msg("validate.taken")})
+	}
+	if err != nil {
+		return h.userErr("index", err)
+	}
+
+	h.sess.Put(ctx, "uid", uid)
+	h.notify(c, uid, notify.EventSignup, r.Username)
+	return c.JSON(http.StatusCreated, personJSON{ID: uid, Handle: r.Username})
+}
+
+func (h *Handler) APISignout(c echo.Context) error {
+	if err := h.sess.Destroy(c.Request().Context()); err != nil {
+		return h.serverErr("index", err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// APIMe returns the signed in person.
+func (h *Handler) APIMe(c echo.Context) error {
+	sess := getSessionData(c)
+	return c.JSON(http.StatusOK, personJSON{
+		ID:     sess.InternalUID,
+		Handle: sess.Handle,
+		Name:   sess.Name,
+		Email:  sess.Email,
+	})
+}
+
+type notificationJSON struct {
+	ID        string    `json:"id"`
+	Event     string    `json:"event"`
+	Title     string    `json:"title"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+	Read      bool      `json:"read"`
+}
+
+type notificationsJSON struct {
+	Items []notificationJSON `json:"items"`
+	Next  string             `json:"next,omitempty"`
+	Prev  string             `json:"prev,omitempty"`
+}
+
+// APINotifications returns a page of the signed in person's notifications. next and prev are
+// cursors for the after and before query parameters.
+func (h *Handler) APINotifications(c echo.Context) error {
+	req, err := h.bindPage(c, 50)
+	if err != nil {
+		return err
+	}
+
+	page, err := h.notifies.List(c.Request().Context(), getUser(c), req, h.cursors)
+	if err != nil {
+		return h.serverErr("index", err)
+	}
+
+	res := notificationsJSON{
+		Items: make([]notificationJSON, 0, len(page.Items)),
+		Next:  page.Next,
+		Prev:  page.Prev,
+	}
+	for _, n := range page.Items {
+		res.Items = append(res.Items, notificationJSON{
+			ID:        n.ID,
+			Event:     string(n.Event),
+			Title:     n.Title,
+			Body:      n.Body,
+			CreatedAt: n.CreatedAt,
+			Read:      n.Read,
+		})
+	}
+	return c.JSON(http.StatusOK, res)
+}
@@ -0,0 +1,56 @@
+package web
+
+import (
+	"context"
+	"encoding/gob"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/labstack/echo/v4"
+)
+
+type FlashLevel string
+
+const (
+	FlashInfo    FlashLevel = "info"
+	FlashSuccess FlashLevel = "success"
+	FlashWarning FlashLevel = "warning"
+	FlashError   FlashLevel = "error"
+)
+
+// Flash is a message for the next page the person sees, typically after a redirect.
+type Flash struct {
+	Level FlashLevel
+	Msg   string
+}
+
+const flashKey = "flash"
+
+func init() {
+	// Sessions are gob encoded.
+	gob.Register([]Flash{})
+}
+
+// flash adds a message to the session, shown by the next page rendered with base.tmpl.
+func (h *Handler) flash(c echo.Context, level FlashLevel, msg string) {
+	ctx := c.Request().Context()
+	flashes, _ := h.sess.Get(ctx, flashKey).([]Flash)
+	h.sess.Put(ctx, flashKey, append(flashes, Flash{Level: level, Msg: msg}))
+}
+
+// LoadFlashes makes Flashes return the flash messages of the session. They are removed from the
+// session when a template reads them, so redirects and JSON responses leave them for the next
+// page.
+func (sd *SessionData) LoadFlashes(sm *scs.SessionManager, ctx context.Context) {
+	sd.flashes = func() []Flash {
+		flashes, _ := sm.Pop(ctx, flashKey).([]Flash)
+		return flashes
+	}
+}
+
+// Flashes returns the flash messages to show, at most once.
+func (sd SessionData) Flashes() []Flash {
+	if sd.flashes == nil {
+		return nil
+	}
+	return sd.flashes()
+}
@@ -0,0 +1,33 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/avalonbits/echo-template-service/embeded"
+	"github.com/labstack/echo/v4"
+)
+
+// redirect sends the browser to url. htmx would follow a redirect and swap the page it lands on
+// into the target, so htmx requests get an HX-Redirect header instead.
+func (h *Handler) redirect(c echo.Context, code int, url string) error {
+	if embeded.IsHTMX(c) {
+		c.Response().Header().Set("HX-Redirect", url)
+		return c.NoContent(http.StatusOK)
+	}
+	return c.Redirect(code, url)
+}
+
+// trigger makes htmx fire event on the page once the response is swapped in. Elements can
+// refresh themselves with hx-trigger="<event> from:body".
+func trigger(c echo.Context, event string) {
+	header := c.Response().Header()
+	events := map[string]any{}
+	if prev := header.Get("HX-Trigger"); prev != "" {
+		json.Unmarshal([]byte(prev), &events)
+	}
+	events[event] = nil
+
+	value, _ := json.Marshal(events)
+	header.Set("HX-Trigger", string(value))
+}
@@ -0,0 +1,127 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/avalonbits/echo-template-service/embeded"
+	"github.com/avalonbits/echo-template-service/service/notify"
+	"github.com/avalonbits/echo-template-service/storage"
+	"github.com/labstack/echo/v4"
+	"github.com/microcosm-cc/bluemonday"
+)
+
+type notificationsPage struct {
+	SessionData
+	Notifications storage.Page[notify.Notification]
+}
+
+func (h *Handler) Notifications(c echo.Context) error {
+	req, err := h.bindPage(c, 50)
+	if err != nil {
+		return err
+	}
+
+	sess := getSessionData(c)
+	page, err := h.notifies.List(c.Request().Context(), sess.InternalUID, req, h.cursors)
+	if err != nil {
+		return h.serverErr("index", err)
+	}
+	// The list may have changed the unread count in the menu.
+	embeded.OOB(c, "unread")
+	return c.Render(http.StatusOK, "notifications", notificationsPage{
+		SessionData:   sess,
+		Notifications: page,
+	})
+}
+
+func (h *Handler) MarkNotificationsRead(c echo.Context) error {
+	if err := h.notifies.MarkRead(c.Request().Context(), getUser(c)); err != nil {
+		return h.serverErr("index", err)
+	}
+	if embeded.IsHTMX(c) {
+		trigger(c, "notificationsRead")
+		return c.NoContent(http.StatusNoContent)
+	}
+	return c.Redirect(http.StatusSeeOther, "/notifications")
+}
+
+type notificationPrefsPage struct {
+	SessionData
+	Events      []notify.EventInfo
+	Channels    []string
+	Preferences notify.Preferences
+}
+
+func (h *Handler) NotificationPreferences(c echo.Context) error {
+	sess := getSessionData(c)
+	prefs, err := h.notifies.Preferences(c.Request().Context(), sess.InternalUID)
+	if err != nil {
+		return h.serverErr("index", err)
+	}
+	return c.Render(http.StatusOK, "notification_prefs", notificationPrefsPage{
+		SessionData: sess,
+		Events:      notify.Events,
+		Channels:    h.notifies.Channels(),
+		Preferences: prefs,
+	})
+}
+
+type notificationPrefsRequest struct {
+	Webhook string `form:"webhook"`
+}
+
+func (r *notificationPrefsRequest) validate(c echo.Context, input *bluemonday.Policy) error {
+	r.Webhook = input.Sanitize(strings.TrimSpace(r.Webhook))
+	if r.Webhook == "" {
+		return nil
+	}
+
+	err := notify.CheckWebhookURL(c.Request().Context(), r.Webhook)
+	if errors.Is(err, notify.ErrPrivateAddress) {
+		return FieldErrors{"webhook": msg("validate.public_url")}
+	}
+	if err != nil {
+		return FieldErrors{"webhook": msg("validate.url")}
+	}
+	return nil
+}
+
+func (h *Handler) SetNotificationPreferences(c echo.Context) error {
+	r := notificationPrefsRequest{}
+	if err := h.validateRequest(c, &r); err != nil {
+		return err
+	}
+
+	// Checkboxes are only sent when checked, so every event/channel pair not in the form is off.
+	prefs := notify.Preferences{Webhook: r.Webhook}
+	for _, info := range notify.Events {
+		for _, name := range h.notifies.Channels() {
+			prefs.Set(info.Event, name, c.FormValue(string(info.Event)+":"+name) == "on")
+		}
+	}
+
+	if err := h.notifies.SetPreferences(c.Request().Context(), getUser(c), prefs); err != nil {
+		return h.serverErr("index", err)
+	}
+	h.flash(c, FlashSuccess, t(c, "flash.preferences_saved"))
+	return h.redirect(c, http.StatusSeeOther, "/notifications/preferences")
+}
+
+// notify sends pid the notification of event without failing the request: the action that
+// triggered it already happened, so we only log if we could not record it. Its title and body are
+// the catalog messages notification.<event>.title and .body, the body formatted with args, in the
+// language pid picked or else the language of the response.
+func (h *Handler) notify(c echo.Context, pid string, event notify.Event, args ...any) {
+	ctx := c.Request().Context()
+	lang := embeded.Lang(c)
+	if p, err := h.users.GetUser(ctx, pid); err == nil && p.Language != "" {
+		lang = embeded.MatchLanguage(p.Language)
+	}
+	cat := embeded.CatalogFor(lang)
+	key := "notification." + string(event)
+	if err := h.notifies.Send(ctx, pid, event, cat.T(key+".title"), cat.T(key+".body", args...)); err != nil {
+		c.Logger().Errorf("error sending %s notification: %v", event, err)
+	}
+}
@@ -0,0 +1,269 @@
+package web
+
+import (
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// operation documents an /api/v1 endpoint. Request and Response are zero values of the types the
+// handler binds and returns.
+type operation struct {
+	Method   string
+	Path     string
+	Summary  string
+	SignedIn bool
+	Query    []parameter
+	Request  any
+	Status   int
+	Response any
+}
+
+type parameter struct {
+	Name        string `json:"name"`
+	In          string `json:"in"`
+	Description string `json:"description,omitempty"`
+	Schema      schema `json:"schema"`
+}
+
+var apiOperations = []operation{
+	{
+		Method:   http.MethodPost,
+		Path:     "/api/v1/signin",
+		Summary:  "Sign in and start a session.",
+		Request:  signinRequest{},
+		Status:   http.StatusOK,
+		Response: personJSON{},
+	},
+	{
+		Method:   http.MethodPost,
+		Path:     "/api/v1/signup",
+		Summary:  "Create an account and start a session.",
+		Request:  signupRequest{},
+		Status:   http.StatusCreated,
+		Response: personJSON{},
+	},
+	{
+		Method:   http.MethodPost,
+		Path:     "/api/v1/signout",
+		Summary:  "End the session.",
+		SignedIn: true,
+		Status:   http.StatusNoContent,
+	},
+	{
+		Method:   http.MethodGet,
+		Path:     "/api/v1/me",
+		Summary:  "The signed in person.",
+		SignedIn: true,
+		Status:   http.StatusOK,
+		Response: personJSON{},
+	},
+	{
+		Method:   http.MethodGet,
+		Path:     "/api/v1/notifications",
+		Summary:  "A page of notifications, newest first.",
+		SignedIn: true,
+		Query: []parameter{
+			{Name: "after", In: "query", Description: "The next cursor of the previous page.", Schema: schema{Type: "string"}},
+			{Name: "before", In: "query", Description: "The prev cursor of the previous page.", Schema: schema{Type: "string"}},
+		},
+		Status:   http.StatusOK,
+		Response: notificationsJSON{},
+	},
+}
+
+// CheckSpec returns the /api routes of routes that have no operation in the OpenAPI spec, and the
+// operations of the spec that have no route.
+func CheckSpec(routes []*echo.Route) []string {
+	var problems []string
+	for _, r := range routes {
+		// Echo adds a not found route to groups with middleware.
+		if !strings.HasPrefix(r.Path, "/api/v1/") || r.Method == echo.RouteNotFound {
+			continue
+		}
+		documented := slices.ContainsFunc(apiOperations, func(op operation) bool {
+			return op.Method == r.Method && op.Path == r.Path
+		})
+		if !documented {
+			problems = append(problems, "undocumented route "+r.Method+" "+r.Path)
+		}
+	}
+	for _, op := range apiOperations {
+		routed := slices.ContainsFunc(routes, func(r *echo.Route) bool {
+			return op.Method == r.Method && op.Path == r.Path
+		})
+		if !routed {
+			problems = append(problems, "operation without a route "+op.Method+" "+op.Path)
+		}
+	}
+	return problems
+}
+
+// OpenAPI serves the OpenAPI 3 spec of the /api/v1 endpoints.
+func (h *Handler) OpenAPI(c echo.Context) error {
+	return c.JSON(http.StatusOK, h.spec)
+}
+
+type spec struct {
+	OpenAPI    string                       `json:"openapi"`
+	Info       map[string]string            `json:"info"`
+	Paths      map[string]map[string]specOp `json:"paths"`
+	Components map[string]map[string]any    `json:"components"`
+}
+
+type specOp struct {
+	Summary     string                  `json:"summary"`
+	OperationID string                  `json:"operationId"`
+	Parameters  []parameter             `json:"parameters,omitempty"`
+	RequestBody *specBody               `json:"requestBody,omitempty"`
+	Responses   map[string]specResponse `json:"responses"`
+	Security    []map[string][]string   `json:"security,omitempty"`
+}
+
+type specBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]mediaType `json:"content"`
+}
+
+type specResponse struct {
+	Description string               `json:"description"`
+	Content     map[string]mediaType `json:"content,omitempty"`
+}
+
+type mediaType struct {
+	Schema schema `json:"schema"`
+}
+
+type schema struct {
+	Ref        string             `json:"$ref,omitempty"`
+	Type       string             `json:"type,omitempty"`
+	Format     string             `json:"format,omitempty"`
+	Pattern    string             `json:"pattern,omitempty"`
+	MinLength  *int               `json:"minLength,omitempty"`
+	MaxLength  *int               `json:"maxLength,omitempty"`
+	Items      *schema            `json:"items,omitempty"`
+	Properties map[string]*schema `json:"properties,omitempty"`
+	Required   []string           `json:"required,omitempty"`
+}
+
+func buildSpec() spec {
+	problemRef := map[string]mediaType{mimeProblemJSON: {Schema: schema{Ref: "#/components/schemas/Problem"}}}
+	s := spec{
+		OpenAPI: "3.0.3",
+		Info:    map[string]string{"title": "echo-template-service", "version": "1"},
+		Paths:   map[string]map[string]specOp{},
+		Components: map[string]map[string]any{
+			"schemas": {"Problem": schemaOf(reflect.TypeOf(Problem{}))},
+			"securitySchemes": {"session": map[string]string{
+				"type": "apiKey",
+				"in":   "cookie",
+				"name": "_s",
+			}},
+		},
+	}
+
+	for _, op := range apiOperations {
+		if s.Paths[op.Path] == nil {
+			s.Paths[op.Path] = map[string]specOp{}
+		}
+
+		so := specOp{
+			Summary:     op.Summary,
+			OperationID: operationID(op),
+			Parameters:  op.Query,
+			Responses: map[string]specResponse{
+				"default": {Description: "An error.", Content: problemRef},
+			},
+		}
+		if op.SignedIn {
+			so.Security = []map[string][]string{{"session": {}}}
+		}
+		if op.Request != nil {
+			so.RequestBody = &specBody{
+				Required: true,
+				Content: map[string]mediaType{
+					echo.MIMEApplicationJSON: {Schema: *schemaOf(reflect.TypeOf(op.Request))},
+				},
+			}
+		}
+		res := specResponse{Description: http.StatusText(op.Status)}
+		if op.Response != nil {
+			res.Content = map[string]mediaType{
+				echo.MIMEApplicationJSON: {Schema: *schemaOf(reflect.TypeOf(op.Response))},
+			}
+		}
+		so.Responses[strconv.Itoa(op.Status)] = res
+		s.Paths[op.Path][strings.ToLower(op.Method)] = so
+	}
+	return s
+}
+
+func operationID(op operation) string {
+	name := strings.ToLower(op.Method)
+	for _, part := range strings.Split(strings.TrimPrefix(op.Path, "/api/v1/"), "/") {
+		name += strings.ToUpper(part[:1]) + part[1:]
+	}
+	return name
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaOf describes t with the json names of its fields and the rules of their validate tags.
+func schemaOf(t reflect.Type) *schema {
+	switch {
+	case t == timeType:
+		return &schema{Type: "string", Format: "date-time"}
+	case t.Kind() == reflect.String:
+		return &schema{Type: "string"}
+	case t.Kind() == reflect.Bool:
+		return &schema{Type: "boolean"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		return &schema{Type: "integer"}
+	case t.Kind() == reflect.Map:
+		return &schema{Type: "object"}
+	case t.Kind() == reflect.Slice:
+		return &schema{Type: "array", Items: schemaOf(t.Elem())}
+	case t.Kind() != reflect.Struct:
+		return &schema{}
+	}
+
+	s := &schema{Type: "object", Properties: map[string]*schema{}}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" || !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		fs := schemaOf(f.Type)
+		for _, rule := range strings.Split(f.Tag.Get("validate"), ",") {
+			rule, arg, _ := strings.Cut(rule, "=")
+			switch rule {
+			case "required":
+				s.Required = append(s.Required, name)
+			case "min":
+				n, _ := strconv.Atoi(arg)
+				fs.MinLength = &n
+			case "max":
+				n, _ := strconv.Atoi(arg)
+				fs.MaxLength = &n
+			case "email":
+				fs.Format = "email"
+			case "pattern":
+				fs.Pattern = Pattern(arg)
+			case "password":
+				fs.Format = "password"
+			}
+		}
+		s.Properties[name] = fs
+	}
+	return s
+}
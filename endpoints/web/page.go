@@ -0,0 +1,33 @@
+package web
+
+import (
+	"net/http"
+
+	"github.com/avalonbits/echo-template-service/storage"
+	"github.com/labstack/echo/v4"
+)
+
+// bindPage binds the after or before query parameter, the cursors the "pagination" template
+// links to, into a request for a page of limit items.
+func (h *Handler) bindPage(c echo.Context, limit int) (storage.PageRequest, error) {
+	req := storage.PageRequest{Limit: limit}
+	err := echo.QueryParamsBinder(c).
+		CustomFunc("after", h.cursorParam(&req.After)).
+		CustomFunc("before", h.cursorParam(&req.Before)).
+		BindError()
+	if err != nil {
+		return storage.PageRequest{}, h.errMsg(http.StatusBadRequest, "error.page")
+	}
+	return req, nil
+}
+
+func (h *Handler) cursorParam(key *string) func([]string) []error {
+	return func(values []string) []error {
+		var err error
+		*key, err = h.cursors.Decode(values[0])
+		if err != nil {
+			return []error{err}
+		}
+		return nil
+	}
+}
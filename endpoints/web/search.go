@@ -0,0 +1,45 @@
+package web
+
+import (
+	"net/http"
+
+	"github.com/avalonbits/echo-template-service/storage/search"
+	"github.com/labstack/echo/v4"
+)
+
+type searchPage struct {
+	SessionData
+	Query   string
+	Results []search.Result
+}
+
+// Search looks for the q query parameter in the signed in person's notifications and, for
+// admins, in everyone's handles and names.
+func (h *Handler) Search(c echo.Context) error {
+	if h.searches == nil {
+		return h.errMsg(http.StatusNotFound, "error.search_unavailable")
+	}
+
+	sess := getSessionData(c)
+	page := searchPage{
+		SessionData: sess,
+		Query:       sanitize(h.input, c.QueryParam("q")),
+	}
+
+	sources := []search.Source{search.Notifications}
+	if sess.Admin {
+		sources = append(sources, search.People)
+	}
+
+	var err error
+	page.Results, err = h.searches.Search(c.Request().Context(), search.Query{
+		Text:    page.Query,
+		Sources: sources,
+		Pid:     sess.InternalUID,
+		Limit:   20,
+	})
+	if err != nil {
+		return h.serverErr("index", err)
+	}
+	return c.Render(http.StatusOK, "search", page)
+}
@@ -0,0 +1,135 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/avalonbits/echo-template-service/embeded"
+	"github.com/avalonbits/echo-template-service/storage/trash"
+	"github.com/labstack/echo/v4"
+	"github.com/microcosm-cc/bluemonday"
+)
+
+type accountPage struct {
+	SessionData
+	RetentionDays int
+	// Language is the language the person picked, or "" for the browser's.
+	Language string
+}
+
+func (h *Handler) Account(c echo.Context) error {
+	sess := getSessionData(c)
+	p, err := h.users.GetUser(c.Request().Context(), sess.InternalUID)
+	if err != nil {
+		return h.serverErr("index", err)
+	}
+	return c.Render(http.StatusOK, "account", accountPage{
+		SessionData:   sess,
+		RetentionDays: days(h.trash.Retention()),
+		Language:      p.Language,
+	})
+}
+
+type languageRequest struct {
+	Lang string `form:"lang"`
+}
+
+func (r *languageRequest) validate(c echo.Context, input *bluemonday.Policy) error {
+	r.Lang = strings.TrimSpace(r.Lang)
+	if r.Lang == "" {
+		return nil
+	}
+	for _, lang := range embeded.Languages() {
+		if lang.Tag == r.Lang {
+			return nil
+		}
+	}
+	return FieldErrors{"lang": msg("validate.choice")}
+}
+
+// SetLanguage sets the language the signed in person sees, whatever their browser asks for.
+func (h *Handler) SetLanguage(c echo.Context) error {
+	r := languageRequest{}
+	if err := h.validateRequest(c, &r); err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+	if err := h.users.SetLanguage(ctx, getUser(c), r.Lang); err != nil {
+		return h.serverErr("index", err)
+	}
+	lang := embeded.MatchLanguage(
+		r.Lang, h.sess.GetString(ctx, "lang"), c.Request().Header.Get("Accept-Language"))
+	// The message is for the next page, which is in the new language.
+	h.flash(c, FlashSuccess, embeded.CatalogFor(lang).T("flash.language_saved"))
+	return h.redirect(c, http.StatusSeeOther, "/account")
+}
+
+// DeleteAccount moves the signed in person to the trash and signs them out.
+func (h *Handler) DeleteAccount(c echo.Context) error {
+	ctx := c.Request().Context()
+	if err := h.users.Delete(ctx, getUser(c)); err != nil {
+		return h.serverErr("account", err)
+	}
+	if err := h.sess.Destroy(ctx); err != nil {
+		destroyCSRFCookie(c)
+		return h.serverErr("index", err)
+	}
+	h.flash(c, FlashInfo, t(c, "flash.account_deleted", days(h.trash.Retention())))
+	return h.redirect(c, http.StatusSeeOther, "/")
+}
+
+type trashPage struct {
+	SessionData
+	RetentionDays int
+	Items         []trash.Item
+}
+
+// Trash lists what the signed in person can restore and, for admins, deleted accounts.
+func (h *Handler) Trash(c echo.Context) error {
+	sess := getSessionData(c)
+	items, err := h.trash.Items(c.Request().Context(), sess.InternalUID, sess.Admin, 50)
+	if err != nil {
+		return h.serverErr("index", err)
+	}
+	return c.Render(http.StatusOK, "trash", trashPage{
+		SessionData:   sess,
+		RetentionDays: days(h.trash.Retention()),
+		Items:         items,
+	})
+}
+
+type restoreRequest struct {
+	Kind string `form:"kind" validate:"required"`
+	ID   string `form:"id" validate:"required"`
+}
+
+func (r *restoreRequest) validate(c echo.Context, input *bluemonday.Policy) error {
+	r.Kind = input.Sanitize(strings.TrimSpace(r.Kind))
+	r.ID = input.Sanitize(strings.TrimSpace(r.ID))
+	return nil
+}
+
+func (h *Handler) RestoreTrash(c echo.Context) error {
+	r := restoreRequest{}
+	if err := h.validateRequest(c, &r); err != nil {
+		return err
+	}
+
+	sess := getSessionData(c)
+	err := h.trash.Restore(c.Request().Context(), sess.InternalUID, sess.Admin, r.Kind, r.ID)
+	if errors.Is(err, trash.ErrNotFound) {
+		return h.errMsg(http.StatusNotFound, "error.trash_expired")
+	}
+	if err != nil {
+		return h.serverErr("index", err)
+	}
+	h.flash(c, FlashSuccess, t(c, "flash.restored"))
+	return h.redirect(c, http.StatusSeeOther, "/trash")
+}
+
+func days(d time.Duration) int {
+	return int(d / (24 * time.Hour))
+}
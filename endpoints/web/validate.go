@@ -0,0 +1,249 @@
+package web
+
+import (
+	"fmt"
+	"net/mail"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/avalonbits/echo-template-service/embeded"
+)
+
+// A request is validated against the validate tags of its fields, a comma separated list of:
+//
+//	required      must not be empty
+//	min=N, max=N  length in characters, when not empty
+//	email         an email address
+//	pattern=name  matches the named pattern, which templates put in pattern attributes
+//	eqfield=Name  equals the field Name
+//	secret        never sent back to the form
+//	password      a credential: secret, and a password in the OpenAPI spec
+//
+// The same tags describe the fields in the OpenAPI spec. Fields are named by their form tag, or by
+// their json tag in the errors of requests with a JSON body.
+
+var usernameRE = regexp.MustCompile("^[a-z][a-z0-9_]*$")
+
+// patterns are the regular expressions pattern rules refer to by name. A value that does not
+// match gets the message validate.pattern.<name>.
+var patterns = map[string]*regexp.Regexp{
+	"username": usernameRE,
+}
+
+// Pattern returns the named pattern for HTML pattern attributes.
+func Pattern(name string) string {
+	re, ok := patterns[name]
+	if !ok {
+		panic(fmt.Sprintf("unknown pattern %q", name))
+	}
+	return re.String()
+}
+
+// Message is a key of the message catalogs with its arguments, translated to the language of
+// the response when it is shown.
+type Message struct {
+	Key  string
+	Args []any
+}
+
+func msg(key string, args ...any) Message {
+	return Message{Key: key, Args: args}
+}
+
+// FieldErrors maps the names of invalid form fields to what is wrong with them.
+type FieldErrors map[string]Message
+
+// byJSONName returns the errors keyed by the json names of the fields of the struct req points
+// to, for requests with a JSON body. Names that are not fields of req are kept.
+func (fe FieldErrors) byJSONName(req any) FieldErrors {
+	_, fields := fieldsOf(req)
+	errs := make(FieldErrors, len(fe))
+	for name, m := range fe {
+		for _, f := range fields {
+			if f.name == name {
+				name = f.json
+				break
+			}
+		}
+		errs[name] = m
+	}
+	return errs
+}
+
+func (fe FieldErrors) Error() string {
+	return fe.text(embeded.CatalogFor(embeded.DefaultLang))
+}
+
+// text lists the errors in one message, for when there is no form to show them next to.
+func (fe FieldErrors) text(cat *embeded.Catalog) string {
+	names := make([]string, 0, len(fe))
+	for name := range fe {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	msgs := make([]string, len(names))
+	for i, name := range names {
+		m := fe[name]
+		msgs[i] = cat.T("form.field_error", name, cat.T(m.Key, m.Args...))
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// translate returns the errors in the language of cat.
+func (fe FieldErrors) translate(cat *embeded.Catalog) map[string]string {
+	if len(fe) == 0 {
+		return nil
+	}
+	errs := make(map[string]string, len(fe))
+	for name, m := range fe {
+		errs[name] = cat.T(m.Key, m.Args...)
+	}
+	return errs
+}
+
+// Form is what a user submitted to a form that failed validation, so it can be shown again.
+type Form struct {
+	Values map[string]string
+	Errors map[string]string
+}
+
+// Value returns what the user typed in the field name, unless it is secret.
+func (f Form) Value(name string) string {
+	return f.Values[name]
+}
+
+// Error returns what is wrong with the field name, or "" if nothing is.
+func (f Form) Error(name string) string {
+	return f.Errors[name]
+}
+
+func (f Form) Invalid(name string) bool {
+	_, ok := f.Errors[name]
+	return ok
+}
+
+type rule struct {
+	name string
+	arg  string
+}
+
+type field struct {
+	name string
+	// json is the name of the field in JSON bodies.
+	json  string
+	index int
+	rules []rule
+}
+
+func (f field) has(name string) (string, bool) {
+	for _, r := range f.rules {
+		if r.name == name {
+			return r.arg, true
+		}
+	}
+	return "", false
+}
+
+// fieldsOf returns the string fields of the struct req points to that have a form or json name.
+func fieldsOf(req any) (reflect.Value, []field) {
+	v := reflect.Indirect(reflect.ValueOf(req))
+	t := v.Type()
+
+	var fields []field
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Type.Kind() != reflect.String {
+			continue
+		}
+		jsonName, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		name := f.Tag.Get("form")
+		if name == "" {
+			name = jsonName
+		}
+		if name == "" || name == "-" {
+			continue
+		}
+		if jsonName == "" || jsonName == "-" {
+			jsonName = name
+		}
+
+		fd := field{name: name, json: jsonName, index: i}
+		if tag := f.Tag.Get("validate"); tag != "" {
+			for _, r := range strings.Split(tag, ",") {
+				name, arg, _ := strings.Cut(r, "=")
+				fd.rules = append(fd.rules, rule{name: name, arg: arg})
+			}
+		}
+		fields = append(fields, fd)
+	}
+	return v, fields
+}
+
+// checkFields applies the validate tags of the struct req points to.
+func checkFields(req any) FieldErrors {
+	v, fields := fieldsOf(req)
+	errs := FieldErrors{}
+	for _, f := range fields {
+		value := v.Field(f.index).String()
+		for _, r := range f.rules {
+			if m, ok := check(v, r, value); !ok {
+				errs[f.name] = m
+				break
+			}
+		}
+	}
+	return errs
+}
+
+func check(v reflect.Value, r rule, value string) (Message, bool) {
+	if value == "" {
+		if r.name == "required" {
+			return msg("validate.required"), false
+		}
+		return Message{}, true
+	}
+
+	switch r.name {
+	case "min":
+		if n, _ := strconv.Atoi(r.arg); utf8.RuneCountInString(value) < n {
+			return msg("validate.min", n), false
+		}
+	case "max":
+		if n, _ := strconv.Atoi(r.arg); utf8.RuneCountInString(value) > n {
+			return msg("validate.max", n), false
+		}
+	case "email":
+		if _, err := mail.ParseAddress(value); err != nil {
+			return msg("validate.email"), false
+		}
+	case "pattern":
+		if !patterns[r.arg].MatchString(value) {
+			return msg("validate.pattern." + r.arg), false
+		}
+	case "eqfield":
+		if other := v.FieldByName(r.arg); other.String() != value {
+			return msg("validate.eqfield." + strings.ToLower(r.arg)), false
+		}
+	}
+	return Message{}, true
+}
+
+// valuesOf returns the values of the struct req points to for showing the form again, leaving
+// out secret fields.
+func valuesOf(req any) map[string]string {
+	v, fields := fieldsOf(req)
+	values := map[string]string{}
+	for _, f := range fields {
+		_, secret := f.has("secret")
+		_, password := f.has("password")
+		if !secret && !password {
+			values[f.name] = v.Field(f.index).String()
+		}
+	}
+	return values
+}
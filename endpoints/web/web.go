@@ -2,19 +2,26 @@ package web
 
 import (
 	"bytes"
+	"cmp"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/mail"
-	"regexp"
 	"strings"
 
 	"github.com/alexedwards/scs/v2"
 	"github.com/avalonbits/echo-template-service/embeded"
 	"github.com/avalonbits/echo-template-service/endpoints"
+	"github.com/avalonbits/echo-template-service/service/notify"
 	"github.com/avalonbits/echo-template-service/service/recaptcha"
 	"github.com/avalonbits/echo-template-service/service/user"
+	"github.com/avalonbits/echo-template-service/storage"
+	"github.com/avalonbits/echo-template-service/storage/search"
+	"github.com/avalonbits/echo-template-service/storage/trash"
 	"github.com/labstack/echo/v4"
 	"github.com/microcosm-cc/bluemonday"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type SessionData struct {
@@ -25,6 +32,16 @@ type SessionData struct {
 	ErrMsg      string
 	CSRFToken   string
 	Recaptcha   bool
+	Unread      int64
+	Admin       bool
+	// Form is what was submitted to the form the page shows, when it failed validation.
+	Form Form
+	// Lang is the language of the page.
+	Lang string
+	// ErrorID identifies the internal error the page shows in the logs and traces.
+	ErrorID string
+
+	flashes func() []Flash
 }
 
 func (sd SessionData) SignedIn() bool {
@@ -36,42 +53,46 @@ type Handler struct {
 	sess      *scs.SessionManager
 	input     *bluemonday.Policy
 	users     *user.Service
+	notifies  *notify.Service
+	searches  *search.Service
+	trash     *trash.Service
 	recaptcha *recaptcha.Service
+	cursors   *storage.Cursors
+	spec      spec
 }
 
 func New(
 	domain endpoints.Domain,
 	sess *scs.SessionManager,
 	users *user.Service,
+	notifies *notify.Service,
+	searches *search.Service,
+	trash *trash.Service,
 	recaptcha *recaptcha.Service,
+	cursors *storage.Cursors,
 ) *Handler {
 	return &Handler{
 		domain:    domain,
 		input:     bluemonday.StrictPolicy(),
 		sess:      sess,
 		users:     users,
+		notifies:  notifies,
+		searches:  searches,
+		trash:     trash,
 		recaptcha: recaptcha,
+		cursors:   cursors,
+		spec:      buildSpec(),
 	}
 }
 
 type signinRequest struct {
-	Username string `form:"username"`
-	Password string `form:"password"`
+	Username string `form:"username" json:"username" validate:"required,pattern=username"`
+	Password string `form:"password" json:"password" validate:"required,min=10,password"`
 }
 
 func (r *signinRequest) validate(c echo.Context, input *bluemonday.Policy) error {
 	r.Username = input.Sanitize(strings.TrimSpace(r.Username))
-	if !usernameRE.MatchString(r.Username) {
-		return fmt.Errorf("invalid username")
-	}
-
 	r.Password = strings.TrimSpace(r.Password)
-	if r.Password == "" {
-		return fmt.Errorf("miising password")
-	}
-	if len(r.Password) < 10 {
-		return fmt.Errorf("invalid password")
-	}
 	return nil
 }
 
@@ -84,36 +105,26 @@ func (h *Handler) Signin(c echo.Context) error {
 	ctx := c.Request().Context()
 	p, err := h.users.Signin(ctx, r.Username, r.Password)
 	if err != nil {
-		return h.errTmpl(http.StatusInternalServerError, "signin_form", err.Error())
+		return h.userErr("signin_form", err)
 	}
 
 	h.sess.Put(ctx, "uid", p.ID)
-	return c.Redirect(http.StatusSeeOther, "/")
+	h.notify(c, p.ID, notify.EventSignin, p.Handle, c.RealIP())
+	h.flash(c, FlashSuccess, t(c, "flash.welcome_back", p.Handle))
+	return h.redirect(c, http.StatusSeeOther, "/")
 }
 
-var usernameRE = regexp.MustCompile("^[a-z][a-z0-9_]*$")
-
 type signupRequest struct {
-	Username  string `form:"username"`
-	Password  string `form:"password"`
-	Confirm   string `form:"confirm"`
-	Recaptcha string `form:"g-recaptcha-response"`
+	Username  string `form:"username" json:"username" validate:"required,pattern=username"`
+	Password  string `form:"password" json:"password" validate:"required,min=10,password"`
+	Confirm   string `form:"confirm" json:"confirm" validate:"required,eqfield=Password,password"`
+	Recaptcha string `form:"g-recaptcha-response" json:"recaptcha" validate:"secret"`
 }
 
 func (r *signupRequest) validate(c echo.Context, input *bluemonday.Policy) error {
 	r.Username = input.Sanitize(strings.TrimSpace(r.Username))
-	if !usernameRE.MatchString(r.Username) {
-		return fmt.Errorf("invalid username")
-	}
-
 	r.Password = strings.TrimSpace(r.Password)
 	r.Confirm = strings.TrimSpace(r.Confirm)
-	if r.Password != r.Confirm || r.Password == "" {
-		return fmt.Errorf("mismatched password/confirm")
-	}
-	if len(r.Password) < 10 {
-		return fmt.Errorf("password too short")
-	}
 	return nil
 }
 
@@ -125,42 +136,43 @@ func (h *Handler) Signup(c echo.Context) error {
 
 	ctx := c.Request().Context()
 	if err := h.recaptcha.Verify(ctx, r.Recaptcha); err != nil {
-		return h.errTmpl(http.StatusBadRequest, "signup_form", "Invalid reCaptcha.")
+		return h.errTmpl(http.StatusBadRequest, "signup_form", "error.recaptcha")
 	}
 
 	uid, err := h.users.Signup(ctx, r.Username, r.Password)
+	if errors.Is(err, user.ErrHandleTaken) {
+		return h.formErr(c, http.StatusConflict, "signup_form", &r, FieldErrors{"username": msg("validate.taken")})
+	}
 	if err != nil {
-		return h.errTmpl(http.StatusInternalServerError, "signup_form", err.Error())
+		return h.userErr("signup_form", err)
 	}
 
 	h.sess.Put(ctx, "uid", uid)
-	return c.Redirect(http.StatusSeeOther, "")
+	h.notify(c, uid, notify.EventSignup, r.Username)
+	h.flash(c, FlashSuccess, t(c, "flash.account_ready", r.Username))
+	return h.redirect(c, http.StatusSeeOther, "/")
 }
 
 func (h *Handler) Signout(c echo.Context) error {
 	if err := h.sess.Destroy(c.Request().Context()); err != nil {
 		destroyCSRFCookie(c)
-		return h.errMsg(http.StatusInternalServerError, err.Error())
+		return h.serverErr("index", err)
 	}
-	return c.Redirect(http.StatusFound, "/")
+	// The session was destroyed, so this starts a new one just for the message.
+	h.flash(c, FlashInfo, t(c, "flash.signed_out"))
+	return h.redirect(c, http.StatusFound, "/")
 }
 
 type verifyEmailRequest struct {
-	Email     string `form:"email"`
-	Recaptcha string `form:"g-recaptcha-response"`
+	Email     string `form:"email" validate:"required,email"`
+	Recaptcha string `form:"g-recaptcha-response" validate:"secret"`
 }
 
 func (r *verifyEmailRequest) validate(c echo.Context, input *bluemonday.Policy) error {
 	r.Email = input.Sanitize(strings.TrimSpace(r.Email))
-	if r.Email == "" {
-		return fmt.Errorf("missing email")
+	if addr, err := mail.ParseAddress(r.Email); err == nil {
+		r.Email = addr.Address
 	}
-	addr, err := mail.ParseAddress(r.Email)
-	if err != nil {
-		return fmt.Errorf("invalid email")
-	}
-	r.Email = addr.Address
-
 	return nil
 }
 
@@ -180,7 +192,7 @@ func (h *Handler) SendVerifyEmail(c echo.Context) error {
 			ctx, sess.Handle, r.Email, sess.InternalUID, h.domain.Domain(),
 		)
 		if err != nil {
-			return h.errTmpl(http.StatusInternalServerError, "email_form", err.Error())
+			return h.serverErr("email_form", err)
 		}
 	*/
 	return c.Redirect(http.StatusSeeOther, "")
@@ -196,99 +208,267 @@ func (h *Handler) VerifyEmail(c echo.Context) error {
 		sess := getSessionData(c)
 			ctx := c.Request().Context()
 			if err := h.users.ValidateToken(ctx, sess.InternalUID, tk); err != nil {
-				return h.errTmpl(http.StatusInternalServerError, "profile", err.Error())
+				return h.serverErr("profile", err)
 			}
 
 			plan, err := h.bills.GetVerifiedPlan(ctx)
 			if err != nil {
-				return h.errTmpl(http.StatusInternalServerError, "profile", err.Error())
+				return h.serverErr("profile", err)
 			}
 			if err := h.bills.Purchase(ctx, sess.InternalUID, plan); err != nil {
-				return h.errTmpl(http.StatusInternalServerError, "profile", err.Error())
+				return h.serverErr("profile", err)
 			}
 	*/
 	return c.Redirect(http.StatusSeeOther, "")
 }
 
+// webError is an error users can see: msg is a message key, or text that is safe to show. The
+// internal error behind it, if any, is in cause and only logged.
 type webError struct {
-	msg  string
-	tmpl string
+	msg   string
+	tmpl  string
+	cause error
+	// fields are set when the error is about the fields of the form the template shows, with the
+	// values that were submitted.
+	fields FieldErrors
+	values map[string]string
 }
 
 func (we webError) Error() string {
 	return we.msg
 }
 
-func PageRenderer(page string) echo.HandlerFunc {
+// views are the views handlers render. PageRenderer checks the pages it renders itself.
+var views = []string{
+	"index",
+	"signin_form",
+	"signup_form",
+	"notifications",
+	"notification_prefs",
+	"search",
+	"account",
+	"trash",
+	"error_403",
+	"error_404",
+	"error_429",
+	"error_500",
+}
+
+// MissingViews returns the views handlers render for which has is false.
+func MissingViews(has func(string) bool) []string {
+	var missing []string
+	for _, v := range views {
+		if !has(v) {
+			missing = append(missing, v)
+		}
+	}
+	return missing
+}
+
+// PageRenderer renders page, which must already be registered with t.
+func PageRenderer(t *embeded.Template, page string) echo.HandlerFunc {
+	if !t.Has(page) {
+		panic(fmt.Errorf("view %q is not registered", page))
+	}
 	return func(c echo.Context) error {
 		sess := getSessionData(c)
 		return c.Render(http.StatusOK, page, sess)
 	}
 }
 
+// statusViews are the views of errors with these statuses, unless they are about a form. Other
+// errors show their message on the page they come from.
+var statusViews = map[int]string{
+	http.StatusForbidden:           "error_403",
+	http.StatusNotFound:            "error_404",
+	http.StatusTooManyRequests:     "error_429",
+	http.StatusInternalServerError: "error_500",
+}
+
+// ErrorHandler shows errors to users. Only public messages are shown: those of webError, and of
+// echo's errors below 500. Anything else is an internal error, logged and traced with an ID that
+// the page shows too, so a report can be matched with what went wrong.
 func ErrorHandler(template *embeded.Template) func(error, echo.Context) {
 	return func(err error, c echo.Context) {
 		if c.Response().Committed {
 			return
 		}
 
+		cat := embeded.CatalogFor(embeded.Lang(c))
 		code := http.StatusInternalServerError
-		msg := err.Error()
-		tmpl := "index"
+		msg := "error.internal"
+		cause := err
+		tmpl := ""
+		var form Form
 		he, ok := err.(*echo.HTTPError)
 		if ok {
 			code = he.Code
 			out, ok := he.Internal.(webError)
-			if ok {
+			switch {
+			case ok:
 				msg = out.msg
-				if out.tmpl != "" {
-					tmpl = out.tmpl
+				cause = out.cause
+				tmpl = out.tmpl
+				if out.fields != nil {
+					form = Form{Values: out.values, Errors: out.fields.translate(cat)}
+					if tmpl == "index" {
+						// There is no form to show the errors next to their fields, so the
+						// message lists them.
+						msg = out.fields.text(cat)
+					}
 				}
-			} else if m, _ := he.Message.(string); m != "" {
-				msg = m
+			case code < http.StatusInternalServerError:
+				// Echo's own messages are safe to show. When they are just the status text,
+				// like "Not Found", the view of the status explains it better.
+				msg = ""
+				if m, _ := he.Message.(string); m != http.StatusText(code) {
+					msg = m
+				}
+				cause = nil
 			}
 		}
+		msg = cat.T(msg)
+
+		var id string
+		if cause != nil || code >= http.StatusInternalServerError {
+			id = logError(c, code, cause)
+		}
 
 		if strings.HasPrefix(c.Request().URL.Path, "/static") {
-			err = c.String(code, msg)
+			err = c.String(code, cmp.Or(msg, http.StatusText(code)))
+			return
+		}
+		if wantsJSON(c) {
+			err = problem(c, code, cmp.Or(msg, http.StatusText(code)), form.Errors, id)
 			return
 		}
 
+		if view := statusView(code); view != "" && form.Errors == nil {
+			tmpl = view
+		} else if tmpl == "" {
+			tmpl = "index"
+		}
 		sess := getSessionData(c)
 		sess.ErrMsg = msg
+		sess.ErrorID = id
+		sess.Form = form
 
 		buf := bytes.Buffer{}
-		template.Render(&buf, tmpl, sess, c)
-		m := buf.String()
-		err = c.HTML(code, m)
+		if rerr := template.Render(&buf, tmpl, sess, c); rerr != nil {
+			logError(c, code, fmt.Errorf("rendering %s: %w", tmpl, rerr))
+			err = c.HTMLBlob(code, embeded.ErrorPage(cat.Lang(), code, cmp.Or(msg, http.StatusText(code)), id))
+			return
+		}
+		err = c.HTMLBlob(code, buf.Bytes())
+	}
+}
+
+func statusView(code int) string {
+	if code > http.StatusInternalServerError {
+		code = http.StatusInternalServerError
 	}
+	return statusViews[code]
+}
+
+// logError logs cause and records it on the trace of the request, returning the ID that
+// correlates them: the trace ID when tracing is on, or else the request ID.
+func logError(c echo.Context, code int, cause error) string {
+	span := trace.SpanFromContext(c.Request().Context())
+	id := c.Response().Header().Get(echo.HeaderXRequestID)
+	if sc := span.SpanContext(); sc.IsValid() {
+		id = sc.TraceID().String()
+	}
+	if cause == nil {
+		cause = errors.New(http.StatusText(code))
+	}
+
+	span.RecordError(cause)
+	span.SetStatus(codes.Error, cause.Error())
+	c.Logger().Errorf("[%s] %s %s: %d: %v", id, c.Request().Method, c.Request().URL.Path, code, cause)
+	return id
 }
 
 func (h *Handler) errMsg(code int, msg string) error {
 	return h.errTmpl(code, "index", msg)
 }
 
+// serverErr reports err as an internal error, unless the database was just too busy to serve the
+// request, in which case the user is asked to try again. Users never see err itself.
+func (h *Handler) serverErr(tmpl string, err error) error {
+	code, msg := http.StatusInternalServerError, "error.internal"
+	if errors.Is(err, storage.ErrBusy) {
+		code, msg = http.StatusServiceUnavailable, "error.busy"
+	}
+	return echo.NewHTTPError(code).WithInternal(webError{msg: msg, tmpl: tmpl, cause: err})
+}
+
+// userErr reports the errors a user can fix with the status they call for, and anything else as
+// a server error.
+func (h *Handler) userErr(tmpl string, err error) error {
+	switch {
+	case errors.Is(err, user.ErrInvalidCredentials):
+		return h.errTmpl(http.StatusUnauthorized, tmpl, "error.invalid_credentials")
+	case errors.Is(err, user.ErrHandleTaken):
+		return h.errTmpl(http.StatusConflict, tmpl, "error.handle_taken")
+	}
+	return h.serverErr(tmpl, err)
+}
+
+// errTmpl shows tmpl with msg, a message key or text shown as is.
 func (h *Handler) errTmpl(code int, tmpl, msg string) error {
 	return echo.NewHTTPError(code).WithInternal(webError{msg: msg, tmpl: tmpl})
 }
 
+// formErr shows tmpl again with what was submitted in req and the errors of its fields. For
+// requests with a JSON body the fields are named as in JSON.
+func (h *Handler) formErr(c echo.Context, code int, tmpl string, req any, errs FieldErrors) error {
+	if boundJSON(c) {
+		errs = errs.byJSONName(req)
+	}
+	return echo.NewHTTPError(code).WithInternal(webError{
+		msg:    "form.invalid",
+		tmpl:   tmpl,
+		fields: errs,
+		values: valuesOf(req),
+	})
+}
+
 type validator interface {
+	// validate normalizes the request and checks what its validate tags cannot express, returning
+	// FieldErrors for problems with specific fields.
 	validate(echo.Context, *bluemonday.Policy) error
 }
 
 func (h *Handler) validateRequest(c echo.Context, req validator, tmpl ...string) error {
-	var err error
-	if err = c.Bind(req); err == nil {
-		err = req.validate(c, h.input)
+	errTmpl := "index"
+	if len(tmpl) > 0 {
+		errTmpl = tmpl[0]
 	}
 
-	errTmpl := "index"
-	if err != nil {
-		if len(tmpl) > 0 {
-			errTmpl = tmpl[0]
+	if err := c.Bind(req); err != nil {
+		if he, ok := err.(*echo.HTTPError); ok {
+			// Keep only the message, the internal error is about echo's binder.
+			err = fmt.Errorf("%v", he.Message)
 		}
 		return h.errTmpl(http.StatusBadRequest, errTmpl, err.Error())
 	}
+
+	errs := FieldErrors{}
+	if err := req.validate(c, h.input); err != nil {
+		var fe FieldErrors
+		if !errors.As(err, &fe) {
+			return h.errTmpl(http.StatusBadRequest, errTmpl, err.Error())
+		}
+		errs = fe
+	}
+	for name, msg := range checkFields(req) {
+		if _, ok := errs[name]; !ok {
+			errs[name] = msg
+		}
+	}
+	if len(errs) > 0 {
+		return h.formErr(c, http.StatusBadRequest, errTmpl, req, errs)
+	}
 	return nil
 }
 
@@ -296,6 +476,11 @@ func sanitize(in *bluemonday.Policy, str string) string {
 	return strings.TrimSpace(in.Sanitize(str))
 }
 
+// t translates key to the language of the response to c.
+func t(c echo.Context, key string, args ...any) string {
+	return embeded.CatalogFor(embeded.Lang(c)).T(key, args...)
+}
+
 func getUser(c echo.Context) string {
 	common := getSessionData(c)
 	return common.InternalUID
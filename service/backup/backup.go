@@ -0,0 +1,103 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/avalonbits/echo-template-service/storage"
+	"github.com/avalonbits/echo-template-service/storage/datastore"
+	"github.com/avalonbits/echo-template-service/storage/jobs"
+)
+
+const (
+	snapshotJob = "backup.snapshot"
+	timeFormat  = "20060102T150405Z"
+)
+
+// Service takes scheduled snapshots of the database into a local directory, keeping only the
+// most recent ones.
+type Service struct {
+	db     *storage.DB[datastore.Queries]
+	dir    string
+	prefix string
+	keep   int
+}
+
+// New registers the snapshot job with runner and schedules it according to spec. Snapshots are
+// named <prefix>-<timestamp>.db and only the newest keep are kept.
+func New(
+	db *storage.DB[datastore.Queries],
+	runner *jobs.Runner,
+	dir, prefix, spec string,
+	keep int,
+) (*Service, error) {
+	if db.Dialect() != storage.SQLite {
+		return nil, storage.ErrNotSQLite
+	}
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+
+	s := &Service{
+		db:     db,
+		dir:    dir,
+		prefix: prefix,
+		keep:   max(1, keep),
+	}
+	runner.Register(snapshotJob, s.snapshot, jobs.Timeout(time.Hour))
+	if err := runner.Schedule(snapshotJob, spec, snapshotJob, nil); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Snapshot backs up the database and deletes the snapshots beyond the retention limit. It
+// returns the path of the new snapshot.
+func (s *Service) Snapshot(ctx context.Context) (string, error) {
+	name := fmt.Sprintf("%s-%s.db", s.prefix, time.Now().UTC().Format(timeFormat))
+	dst := filepath.Join(s.dir, name)
+	if err := s.db.Backup(ctx, dst); err != nil {
+		return "", err
+	}
+	return dst, s.rotate()
+}
+
+func (s *Service) snapshot(ctx context.Context, _ jobs.Job) error {
+	dst, err := s.Snapshot(ctx)
+	if err != nil {
+		return err
+	}
+	log.Printf("database snapshot written to %s", dst)
+	return nil
+}
+
+func (s *Service) rotate() error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+
+	// Timestamps sort lexicographically, so the oldest snapshots come first.
+	var snapshots []string
+	for _, e := range entries {
+		name := e.Name()
+		if e.Type().IsRegular() && strings.HasPrefix(name, s.prefix+"-") && strings.HasSuffix(name, ".db") {
+			snapshots = append(snapshots, name)
+		}
+	}
+	slices.Sort(snapshots)
+
+	for len(snapshots) > s.keep {
+		if err := os.Remove(filepath.Join(s.dir, snapshots[0])); err != nil {
+			return err
+		}
+		snapshots = snapshots[1:]
+	}
+	return nil
+}
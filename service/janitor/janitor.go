@@ -0,0 +1,133 @@
+package janitor
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/avalonbits/echo-template-service/storage"
+	"github.com/avalonbits/echo-template-service/storage/datastore"
+	"github.com/avalonbits/echo-template-service/storage/jobs"
+	"github.com/avalonbits/echo-template-service/storage/sessionstore"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const (
+	sweepJob = "janitor.sweep"
+
+	doneJobsTTL          = 7 * 24 * time.Hour
+	deadJobsTTL          = 30 * 24 * time.Hour
+	readNotificationsTTL = 90 * 24 * time.Hour
+)
+
+// Service purges rows that outlived their usefulness: expired registration tokens and sessions,
+// old finished jobs and notifications the user already read.
+type Service struct {
+	db      *storage.DB[datastore.Queries]
+	deleted metric.Int64Counter
+}
+
+// New registers the sweep job with runner and schedules it to run every interval.
+func New(
+	db *storage.DB[datastore.Queries],
+	runner *jobs.Runner,
+	meter metric.Meter,
+	interval time.Duration,
+) (*Service, error) {
+	deleted, err := meter.Int64Counter(
+		"janitor.deleted_rows",
+		metric.WithDescription("Number of expired rows deleted by the janitor."),
+		metric.WithUnit("{row}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Service{
+		db:      db,
+		deleted: deleted,
+	}
+	runner.Register(sweepJob, s.sweep)
+	if err := runner.Schedule(sweepJob, "@every "+interval.String(), sweepJob, nil); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Swept is how many rows Sweep deleted, per kind of data.
+type Swept struct {
+	Tokens        int64
+	Sessions      int64
+	Jobs          int64
+	Notifications int64
+}
+
+func (s Swept) Total() int64 {
+	return s.Tokens + s.Sessions + s.Jobs + s.Notifications
+}
+
+// Sweep deletes everything that expired as of now.
+func (s *Service) Sweep(ctx context.Context, now time.Time) (Swept, error) {
+	now = now.UTC()
+	before := func(ttl time.Duration) sql.NullString {
+		return sql.NullString{String: now.Add(-ttl).Format(time.RFC3339), Valid: true}
+	}
+
+	swept := Swept{}
+	err := s.db.Write(ctx, func(queries *datastore.Queries) error {
+		var err error
+		swept.Tokens, err = queries.DeleteExpiredTokens(ctx, now.Format(time.RFC3339))
+		if err != nil {
+			return err
+		}
+		swept.Sessions, err = queries.DeleteExpiredSessions(ctx, sessionstore.Expiry(now))
+		if err != nil {
+			return err
+		}
+
+		done, err := queries.DeleteFinishedJobs(ctx, datastore.DeleteFinishedJobsParams{
+			Status:     "done",
+			FinishedAt: before(doneJobsTTL),
+		})
+		if err != nil {
+			return err
+		}
+		dead, err := queries.DeleteFinishedJobs(ctx, datastore.DeleteFinishedJobsParams{
+			Status:     "dead",
+			FinishedAt: before(deadJobsTTL),
+		})
+		if err != nil {
+			return err
+		}
+		swept.Jobs = done + dead
+
+		swept.Notifications, err = queries.DeleteReadNotifications(ctx, before(readNotificationsTTL))
+		return err
+	})
+	if err != nil {
+		return Swept{}, err
+	}
+
+	s.record(ctx, "RegistrationToken", swept.Tokens)
+	s.record(ctx, "sessions", swept.Sessions)
+	s.record(ctx, "Job", swept.Jobs)
+	s.record(ctx, "Notification", swept.Notifications)
+	return swept, nil
+}
+
+func (s *Service) sweep(ctx context.Context, _ jobs.Job) error {
+	swept, err := s.Sweep(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+	if total := swept.Total(); total > 0 {
+		log.Printf("janitor deleted %d rows: %+v", total, swept)
+	}
+	return nil
+}
+
+func (s *Service) record(ctx context.Context, table string, n int64) {
+	s.deleted.Add(ctx, n, metric.WithAttributes(attribute.String("table", table)))
+}
@@ -0,0 +1,243 @@
+package janitor
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/avalonbits/echo-template-service/storage"
+	"github.com/avalonbits/echo-template-service/storage/datastore"
+	"github.com/avalonbits/echo-template-service/storage/jobs"
+	"github.com/avalonbits/echo-template-service/storage/sessionstore"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+var now = time.Date(2024, time.June, 1, 12, 0, 0, 0, time.UTC)
+
+func ago(d time.Duration) string {
+	return now.Add(-d).Format(time.RFC3339)
+}
+
+func at(t string) sql.NullString {
+	return sql.NullString{String: t, Valid: true}
+}
+
+func seed(t *testing.T, db *storage.DB[datastore.Queries]) {
+	t.Helper()
+	ctx := context.Background()
+	err := db.Write(ctx, func(queries *datastore.Queries) error {
+		for pid, expires := range map[string]string{
+			"expired": ago(time.Hour),
+			"valid":   ago(-time.Hour),
+		} {
+			err := queries.SetRegistrationToken(ctx, datastore.SetRegistrationTokenParams{
+				Pid:     pid,
+				Email:   pid + "@example.com",
+				Token:   "token-" + pid,
+				Expires: expires,
+				Refresh: expires,
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		for token, expiry := range map[string]time.Time{
+			"expired": now.Add(-time.Minute),
+			"valid":   now.Add(time.Hour),
+		} {
+			err := queries.CommitSession(ctx, datastore.CommitSessionParams{
+				Token:  token,
+				Data:   []byte("data"),
+				Expiry: sessionstore.Expiry(expiry),
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, job := range []struct {
+			id       string
+			status   string
+			finished time.Duration
+		}{
+			{"done-old", "done", doneJobsTTL + time.Hour},
+			{"done-new", "done", doneJobsTTL - time.Hour},
+			{"dead-old", "dead", deadJobsTTL + time.Hour},
+			{"dead-new", "dead", deadJobsTTL - time.Hour},
+			{"pending", "pending", 0},
+		} {
+			err := queries.EnqueueJob(ctx, datastore.EnqueueJobParams{
+				ID:          job.id,
+				Kind:        "test",
+				Payload:     []byte("{}"),
+				MaxAttempts: 1,
+				RunAt:       ago(0),
+				CreatedAt:   ago(60 * 24 * time.Hour),
+			})
+			if err != nil {
+				return err
+			}
+			switch job.status {
+			case "done":
+				err = queries.CompleteJob(ctx, datastore.CompleteJobParams{
+					FinishedAt: at(ago(job.finished)),
+					ID:         job.id,
+				})
+			case "dead":
+				err = queries.BuryJob(ctx, datastore.BuryJobParams{
+					LastError:  at("failed"),
+					FinishedAt: at(ago(job.finished)),
+					ID:         job.id,
+				})
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, n := range []struct {
+			pid  string
+			read time.Duration
+		}{
+			{"old", readNotificationsTTL + time.Hour},
+			{"recent", readNotificationsTTL - time.Hour},
+			{"unread", 0},
+		} {
+			err := queries.CreateUser(ctx, datastore.CreateUserParams{
+				ID:        n.pid,
+				Handle:    n.pid,
+				CreatedAt: ago(365 * 24 * time.Hour),
+				Password:  []byte("password"),
+				Salt:      []byte("salt"),
+			})
+			if err != nil {
+				return err
+			}
+			err = queries.CreateNotification(ctx, datastore.CreateNotificationParams{
+				ID:        "ntf-" + n.pid,
+				Pid:       n.pid,
+				Event:     "test",
+				Title:     "title",
+				Body:      "body",
+				Inapp:     true,
+				CreatedAt: ago(365 * 24 * time.Hour),
+			})
+			if err != nil {
+				return err
+			}
+			if n.read == 0 {
+				continue
+			}
+			err = queries.MarkNotificationsRead(ctx, datastore.MarkNotificationsReadParams{
+				ReadAt: at(ago(n.read)),
+				Pid:    n.pid,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// left returns the keys of the rows left in table, sorted.
+func left(t *testing.T, db *storage.DB[datastore.Queries], table, key string) []string {
+	t.Helper()
+	rows, err := db.RDBMS().Query(fmt.Sprintf("SELECT %s FROM %s ORDER BY 1", key, table))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var k string
+		if err := rows.Scan(&k); err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, k)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func TestSweep(t *testing.T) {
+	db := storage.TestDB(datastore.Migrations, datastore.Factory)
+	defer db.Close()
+	reader := sdkmetric.NewManualReader()
+	meter := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)).Meter("test")
+
+	s, err := New(db, jobs.New(db), meter, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seed(t, db)
+
+	swept, err := s.Sweep(context.Background(), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Swept{Tokens: 1, Sessions: 1, Jobs: 2, Notifications: 1}
+	if swept != want {
+		t.Errorf("Sweep = %+v, want %+v", swept, want)
+	}
+	if swept.Total() != 5 {
+		t.Errorf("Total = %d, want 5", swept.Total())
+	}
+
+	for _, tt := range []struct {
+		table, key string
+		want       []string
+	}{
+		{"RegistrationToken", "pid", []string{"valid"}},
+		{"sessions", "token", []string{"valid"}},
+		{"Job", "id", []string{"dead-new", "done-new", "pending"}},
+		{"Notification", "id", []string{"ntf-recent", "ntf-unread"}},
+	} {
+		if got := left(t, db, tt.table, tt.key); !slices.Equal(got, tt.want) {
+			t.Errorf("%s left %v, want %v", tt.table, got, tt.want)
+		}
+	}
+
+	rm := metricdata.ResourceMetrics{}
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	counted := map[string]int64{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name != "janitor.deleted_rows" {
+				continue
+			}
+			for _, dp := range m.Data.(metricdata.Sum[int64]).DataPoints {
+				table, _ := dp.Attributes.Value("table")
+				counted[table.AsString()] += dp.Value
+			}
+		}
+	}
+	wantCounted := map[string]int64{"RegistrationToken": 1, "sessions": 1, "Job": 2, "Notification": 1}
+	for table, n := range wantCounted {
+		if counted[table] != n {
+			t.Errorf("janitor.deleted_rows{table=%s} = %d, want %d", table, counted[table], n)
+		}
+	}
+
+	// Nothing is left to sweep.
+	swept, err = s.Sweep(context.Background(), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if swept.Total() != 0 {
+		t.Errorf("second Sweep = %+v, want nothing deleted", swept)
+	}
+}
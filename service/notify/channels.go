@@ -0,0 +1,250 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	ChannelEmail   = "email"
+	ChannelWebhook = "webhook"
+)
+
+type Email struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+// NewEmail returns a channel that sends plain text emails through the SMTP server at addr
+// (host:port). If username is empty, no authentication is done.
+func NewEmail(addr, from, username, password string) *Email {
+	var auth smtp.Auth
+	if username != "" {
+		host, _, _ := strings.Cut(addr, ":")
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+	return &Email{
+		addr: addr,
+		from: from,
+		auth: auth,
+	}
+}
+
+func (e *Email) Name() string {
+	return ChannelEmail
+}
+
+// Deliver sends msg like smtp.SendMail does, upgrading to TLS when the server supports it, but
+// gives up when ctx is done: its deadline is the connection's and cancelling it closes the
+// connection.
+func (e *Email) Deliver(ctx context.Context, to Recipient, msg Message) error {
+	if to.Email == "" {
+		return fmt.Errorf("%s has no email address", to.Handle)
+	}
+
+	buf := bytes.Buffer{}
+	fmt.Fprintf(&buf, "From: %s\r\n", e.from)
+	fmt.Fprintf(&buf, "To: %s\r\n", to.Email)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Title))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	buf.WriteString(msg.Body)
+
+	dialer := net.Dialer{Timeout: 10 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", e.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	host, _, err := net.SplitHostPort(e.addr)
+	if err != nil {
+		return err
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if e.auth != nil {
+		if err := c.Auth(e.auth); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(e.from); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to.Email); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+type Webhook struct {
+	client *http.Client
+}
+
+// NewWebhook returns a channel that POSTs a JSON document to the URL each user configured on
+// their preferences page. Users pick the URL, so the client only connects to public addresses:
+// the check runs on the address being dialed, after DNS resolution, so a name that resolves to a
+// public address when the URL is saved and to a private one later is still refused. Redirects
+// are not followed and there is no proxy, since either would connect somewhere else.
+func NewWebhook() *Webhook {
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			addr, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !publicAddr(addr.Addr()) {
+				return fmt.Errorf("%w: %s", ErrPrivateAddress, addr.Addr())
+			}
+			return nil
+		},
+	}
+	return &Webhook{
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+			Transport: &http.Transport{
+				DialContext:           dialer.DialContext,
+				TLSHandshakeTimeout:   5 * time.Second,
+				ResponseHeaderTimeout: 5 * time.Second,
+				MaxIdleConns:          10,
+				IdleConnTimeout:       time.Minute,
+			},
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+var ErrPrivateAddress = errors.New("webhook address is not public")
+
+// CheckWebhookURL returns an error unless rawURL is an http or https URL whose host resolves only
+// to public addresses. Deliver checks the address it connects to again, this is so users learn
+// about a bad URL when they save it.
+func CheckWebhookURL(ctx context.Context, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if u.Hostname() == "" || (u.Scheme != "https" && u.Scheme != "http") {
+		return fmt.Errorf("not an http or https URL: %q", rawURL)
+	}
+
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", u.Hostname())
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		if !publicAddr(addr) {
+			return fmt.Errorf("%w: %s", ErrPrivateAddress, addr)
+		}
+	}
+	return nil
+}
+
+// nonPublic are ranges not covered by the netip.Addr methods that must not be reachable from
+// webhooks: "this network", carrier-grade NAT and benchmarking.
+var nonPublic = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+}
+
+// publicAddr reports whether addr is a public unicast address, so not loopback, private (RFC
+// 1918 and unique local), link-local like the 169.254.169.254 cloud metadata service, or
+// multicast.
+func publicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+	for _, prefix := range nonPublic {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+func (w *Webhook) Name() string {
+	return ChannelWebhook
+}
+
+type webhookPayload struct {
+	Event  Event  `json:"event"`
+	Handle string `json:"handle"`
+	Title  string `json:"title"`
+	Body   string `json:"body"`
+}
+
+func (w *Webhook) Deliver(ctx context.Context, to Recipient, msg Message) error {
+	if to.Webhook == "" {
+		return fmt.Errorf("%s has no webhook configured", to.Handle)
+	}
+
+	data, err := json.Marshal(webhookPayload{
+		Event:  msg.Event,
+		Handle: to.Handle,
+		Title:  msg.Title,
+		Body:   msg.Body,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, to.Webhook, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Content-Type", "application/json")
+
+	res, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("webhook returned status %d", res.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"mime"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"net/netip"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPublicAddr(t *testing.T) {
+	tests := []struct {
+		addr   string
+		public bool
+	}{
+		{"93.184.215.14", true},
+		{"2606:2800:21f:cb07:6820:80da:af6b:8b2c", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"fc00::1", false},
+		{"0.0.0.0", false},
+		{"100.64.0.1", false},
+		{"224.0.0.1", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:169.254.169.254", false},
+	}
+	for _, tt := range tests {
+		if got := publicAddr(netip.MustParseAddr(tt.addr)); got != tt.public {
+			t.Errorf("publicAddr(%s) = %v, want %v", tt.addr, got, tt.public)
+		}
+	}
+}
+
+func TestCheckWebhookURL(t *testing.T) {
+	tests := []struct {
+		url     string
+		private bool
+	}{
+		{"http://127.0.0.1:8080/hook", true},
+		{"http://169.254.169.254/latest/meta-data", true},
+		{"https://10.0.0.1/hook", true},
+		{"http://[::1]/hook", true},
+		{"http://localhost/hook", true},
+		{"https://93.184.215.14/hook", false},
+	}
+	for _, tt := range tests {
+		err := CheckWebhookURL(context.Background(), tt.url)
+		if got := errors.Is(err, ErrPrivateAddress); got != tt.private {
+			t.Errorf("CheckWebhookURL(%s) = %v, want private %v", tt.url, err, tt.private)
+		}
+		if !tt.private && err != nil {
+			t.Errorf("CheckWebhookURL(%s) = %v, want nil", tt.url, err)
+		}
+	}
+
+	for _, bad := range []string{"ftp://example.com/", "example.com/hook", "http:///hook"} {
+		if err := CheckWebhookURL(context.Background(), bad); err == nil {
+			t.Errorf("CheckWebhookURL(%s) = nil, want an error", bad)
+		}
+	}
+}
+
+func TestWebhookRefusesPrivateAddresses(t *testing.T) {
+	called := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer srv.Close()
+
+	err := NewWebhook().Deliver(context.Background(), Recipient{Handle: "alice", Webhook: srv.URL},
+		Message{Event: EventSignin, Title: "title", Body: "body"})
+	if !errors.Is(err, ErrPrivateAddress) {
+		t.Fatalf("Deliver to %s = %v, want ErrPrivateAddress", srv.URL, err)
+	}
+	if called {
+		t.Fatal("Deliver reached a loopback server")
+	}
+}
+
+// smtpServer accepts one connection on a local port and answers it like an SMTP server without
+// extensions, sending the message it gets on the returned channel. If silent, it never answers.
+func smtpServer(t *testing.T, silent bool) (string, <-chan string) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	msgs := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if silent {
+			conn.Read(make([]byte, 1))
+			return
+		}
+
+		tp := textproto.NewConn(conn)
+		tp.PrintfLine("220 localhost ready")
+		for {
+			line, err := tp.ReadLine()
+			if err != nil {
+				return
+			}
+			switch cmd, _, _ := strings.Cut(line, " "); strings.ToUpper(cmd) {
+			case "EHLO", "HELO", "MAIL", "RCPT":
+				tp.PrintfLine("250 OK")
+			case "DATA":
+				tp.PrintfLine("354 go ahead")
+				data, err := tp.ReadDotBytes()
+				if err != nil {
+					return
+				}
+				msgs <- string(data)
+				tp.PrintfLine("250 OK")
+			case "QUIT":
+				tp.PrintfLine("221 bye")
+				return
+			default:
+				tp.PrintfLine("502 not implemented")
+			}
+		}
+	}()
+	return l.Addr().String(), msgs
+}
+
+func TestEmailDeliver(t *testing.T) {
+	addr, msgs := smtpServer(t, false)
+	email := NewEmail(addr, "household@example.com", "", "")
+
+	err := email.Deliver(context.Background(), Recipient{Handle: "alice", Email: "alice@example.com"},
+		Message{Event: EventSignin, Title: "Novo acesso à conta", Body: "Alguém entrou na sua conta."})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := mail.ReadMessage(strings.NewReader(<-msgs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := msg.Header.Get("To"); got != "alice@example.com" {
+		t.Errorf("To = %q", got)
+	}
+	raw := msg.Header.Get("Subject")
+	if !strings.HasPrefix(raw, "=?utf-8?q?") {
+		t.Errorf("Subject %q is not Q-encoded", raw)
+	}
+	if subject, err := new(mime.WordDecoder).DecodeHeader(raw); err != nil || subject != "Novo acesso à conta" {
+		t.Errorf("Subject decodes to %q, %v", subject, err)
+	}
+}
+
+func TestEmailSubjectCannotAddHeaders(t *testing.T) {
+	addr, msgs := smtpServer(t, false)
+	email := NewEmail(addr, "household@example.com", "", "")
+
+	err := email.Deliver(context.Background(), Recipient{Handle: "alice", Email: "alice@example.com"},
+		Message{Event: EventSignin, Title: "hi\r\nBcc: mallory@example.com", Body: "body"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := mail.ReadMessage(strings.NewReader(<-msgs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bcc := msg.Header.Get("Bcc"); bcc != "" {
+		t.Errorf("the subject added a Bcc header: %q", bcc)
+	}
+}
+
+func TestEmailDeliverStopsWithContext(t *testing.T) {
+	addr, _ := smtpServer(t, true)
+	email := NewEmail(addr, "household@example.com", "", "")
+	to := Recipient{Handle: "alice", Email: "alice@example.com"}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := email.Deliver(ctx, to, Message{Title: "title", Body: "body"}); err == nil {
+		t.Fatal("Deliver to a server that never answers succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Deliver took %s, ignoring the context deadline", elapsed)
+	}
+}
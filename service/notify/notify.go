@@ -0,0 +1,387 @@
+package notify
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/avalonbits/echo-template-service/service/user"
+	"github.com/avalonbits/echo-template-service/storage"
+	"github.com/avalonbits/echo-template-service/storage/datastore"
+	"github.com/avalonbits/echo-template-service/storage/jobs"
+	"github.com/avalonbits/echo-template-service/storage/keyring"
+	"github.com/oklog/ulid"
+)
+
+type Event string
+
+const (
+	EventSignup        Event = "account.signup"
+	EventSignin        Event = "account.signin"
+	EventEmailVerified Event = "account.email_verified"
+)
+
+type EventInfo struct {
+	Event       Event
+	Description string
+}
+
+// Events lists every event users can route to a channel, in the order they show up on the
+// preferences page.
+var Events = []EventInfo{
+	{Event: EventSignup, Description: "Welcome message"},
+	{Event: EventSignin, Description: "New sign in to your account"},
+	{Event: EventEmailVerified, Description: "Email address verified"},
+}
+
+// ChannelInApp is always available. Its notifications live in the Notification table and show up
+// under the menu bell, so it never goes through the delivery queue.
+const ChannelInApp = "inapp"
+
+type Recipient struct {
+	PID     string
+	Handle  string
+	Email   string
+	Webhook string
+}
+
+type Message struct {
+	Event Event
+	Title string
+	Body  string
+}
+
+// Channel delivers notifications outside the app. Deliver runs as a background job, never while
+// serving a request, so it is fine for it to be slow.
+type Channel interface {
+	Name() string
+	Deliver(ctx context.Context, to Recipient, msg Message) error
+}
+
+type Service struct {
+	db       *storage.DB[datastore.Queries]
+	keys     *keyring.Keyring
+	channels map[string]Channel
+	names    []string
+}
+
+const deliverJob = "notify.deliver"
+
+// New returns the notification service. keys decrypts the email address of recipients.
+func New(
+	db *storage.DB[datastore.Queries],
+	keys *keyring.Keyring,
+	runner *jobs.Runner,
+	channels ...Channel,
+) *Service {
+	s := &Service{
+		db:       db,
+		keys:     keys,
+		channels: map[string]Channel{},
+		names:    []string{ChannelInApp},
+	}
+	for _, ch := range channels {
+		s.channels[ch.Name()] = ch
+		s.names = append(s.names, ch.Name())
+	}
+	runner.Register(deliverJob, s.deliver, jobs.Concurrency(4), jobs.Timeout(time.Minute))
+	return s
+}
+
+// Channels returns the name of every channel a user can pick from.
+func (s *Service) Channels() []string {
+	return s.names
+}
+
+type Notification struct {
+	ID        string
+	Event     Event
+	Title     string
+	Body      string
+	CreatedAt time.Time
+	Read      bool
+}
+
+// Send records a notification for pid and queues a delivery job for every external channel the
+// user enabled for event.
+func (s *Service) Send(ctx context.Context, pid string, event Event, title, body string) error {
+	now := time.Now().UTC()
+	nowStr := now.Format(time.RFC3339)
+
+	return s.db.Write(ctx, func(queries *datastore.Queries) error {
+		prefs, err := s.preferences(ctx, queries, pid)
+		if err != nil {
+			return err
+		}
+		to, err := s.recipient(ctx, queries, pid)
+		if err != nil {
+			return err
+		}
+
+		nid, err := newID(now)
+		if err != nil {
+			return err
+		}
+		err = queries.CreateNotification(ctx, datastore.CreateNotificationParams{
+			ID:        nid,
+			Pid:       pid,
+			Event:     string(event),
+			Title:     title,
+			Body:      body,
+			Inapp:     prefs.Enabled(event, ChannelInApp),
+			CreatedAt: nowStr,
+		})
+		if err != nil {
+			return err
+		}
+
+		for name := range s.channels {
+			if !prefs.Enabled(event, name) || !reachable(name, to) {
+				continue
+			}
+			err := jobs.Enqueue(ctx, queries, deliverJob, delivery{Nid: nid, Channel: name})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *Service) Unread(ctx context.Context, pid string) (int64, error) {
+	var count int64
+	err := s.db.Read(ctx, func(queries *datastore.Queries) error {
+		var err error
+		count, err = queries.CountUnreadNotifications(ctx, pid)
+		return err
+	})
+	return count, err
+}
+
+// List returns a page of the in-app notifications of pid, newest first. Cursors are encoded with
+// cursors.
+func (s *Service) List(
+	ctx context.Context,
+	pid string,
+	req storage.PageRequest,
+	cursors *storage.Cursors,
+) (storage.Page[Notification], error) {
+	var page storage.Page[datastore.Notification]
+	err := s.db.Read(ctx, func(queries *datastore.Queries) error {
+		var err error
+		page, err = storage.Paginate(req, cursors,
+			func(n datastore.Notification) string { return n.ID },
+			func(key string, backward bool, limit int64) ([]datastore.Notification, error) {
+				if backward {
+					return queries.ListNotificationsBefore(ctx, datastore.ListNotificationsBeforeParams{
+						Pid:    pid,
+						Before: key,
+						Limit:  limit,
+					})
+				}
+				return queries.ListNotifications(ctx, datastore.ListNotificationsParams{
+					Pid:   pid,
+					After: key,
+					Limit: limit,
+				})
+			})
+		return err
+	})
+	if err != nil {
+		return storage.Page[Notification]{}, err
+	}
+
+	res := storage.Page[Notification]{
+		Items: make([]Notification, 0, len(page.Items)),
+		Next:  page.Next,
+		Prev:  page.Prev,
+	}
+	for _, n := range page.Items {
+		createdAt, _ := time.Parse(time.RFC3339, n.CreatedAt)
+		res.Items = append(res.Items, Notification{
+			ID:        n.ID,
+			Event:     Event(n.Event),
+			Title:     n.Title,
+			Body:      n.Body,
+			CreatedAt: createdAt,
+			Read:      n.ReadAt.Valid,
+		})
+	}
+	return res, nil
+}
+
+func (s *Service) MarkRead(ctx context.Context, pid string) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	return s.db.Retry(ctx, func() error {
+		return s.db.Write(ctx, func(queries *datastore.Queries) error {
+			return queries.MarkNotificationsRead(ctx, datastore.MarkNotificationsReadParams{
+				ReadAt: sql.NullString{String: now, Valid: true},
+				Pid:    pid,
+			})
+		})
+	})
+}
+
+type Preferences struct {
+	Webhook string
+	enabled map[Event]map[string]bool
+}
+
+// Enabled reports whether event should be sent through channel. Users that never saved their
+// preferences only get in-app notifications.
+func (p Preferences) Enabled(event Event, channel string) bool {
+	if on, ok := p.enabled[event][channel]; ok {
+		return on
+	}
+	return channel == ChannelInApp
+}
+
+func (p *Preferences) Set(event Event, channel string, enabled bool) {
+	if p.enabled == nil {
+		p.enabled = map[Event]map[string]bool{}
+	}
+	if p.enabled[event] == nil {
+		p.enabled[event] = map[string]bool{}
+	}
+	p.enabled[event][channel] = enabled
+}
+
+func (s *Service) Preferences(ctx context.Context, pid string) (Preferences, error) {
+	var prefs Preferences
+	err := s.db.Read(ctx, func(queries *datastore.Queries) error {
+		var err error
+		prefs, err = s.preferences(ctx, queries, pid)
+		return err
+	})
+	return prefs, err
+}
+
+func (s *Service) preferences(
+	ctx context.Context, queries *datastore.Queries, pid string) (Preferences, error) {
+	prefs := Preferences{}
+	list, err := queries.GetNotificationPreferences(ctx, pid)
+	if err != nil {
+		return prefs, err
+	}
+	for _, p := range list {
+		prefs.Set(Event(p.Event), p.Channel, p.Enabled)
+	}
+
+	prefs.Webhook, err = queries.GetNotificationWebhook(ctx, pid)
+	if err != nil && !storage.NoRows(err) {
+		return prefs, err
+	}
+	return prefs, nil
+}
+
+func (s *Service) SetPreferences(ctx context.Context, pid string, prefs Preferences) error {
+	return s.db.Write(ctx, func(queries *datastore.Queries) error {
+		for _, info := range Events {
+			for _, name := range s.names {
+				err := queries.SetNotificationPreference(ctx, datastore.SetNotificationPreferenceParams{
+					Pid:     pid,
+					Event:   string(info.Event),
+					Channel: name,
+					Enabled: prefs.Enabled(info.Event, name),
+				})
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		if prefs.Webhook == "" {
+			return queries.DeleteNotificationWebhook(ctx, pid)
+		}
+		return queries.SetNotificationWebhook(ctx, datastore.SetNotificationWebhookParams{
+			Pid: pid,
+			Url: prefs.Webhook,
+		})
+	})
+}
+
+type delivery struct {
+	Nid     string `json:"nid"`
+	Channel string `json:"channel"`
+}
+
+func (s *Service) deliver(ctx context.Context, job jobs.Job) error {
+	d := delivery{}
+	if err := job.Decode(&d); err != nil {
+		return jobs.Permanent(err)
+	}
+	ch, ok := s.channels[d.Channel]
+	if !ok {
+		return jobs.Permanent(fmt.Errorf("unknown channel %q", d.Channel))
+	}
+
+	var n datastore.Notification
+	var to Recipient
+	err := s.db.Read(ctx, func(queries *datastore.Queries) error {
+		var err error
+		n, err = queries.GetNotification(ctx, d.Nid)
+		if err != nil {
+			return err
+		}
+		to, err = s.recipient(ctx, queries, n.Pid)
+		return err
+	})
+	if storage.NoRows(err) {
+		// The notification or its recipient is gone, nothing left to deliver.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return ch.Deliver(ctx, to, Message{
+		Event: Event(n.Event),
+		Title: n.Title,
+		Body:  n.Body,
+	})
+}
+
+func (s *Service) recipient(
+	ctx context.Context, queries *datastore.Queries, pid string) (Recipient, error) {
+	p, err := queries.GetPerson(ctx, pid)
+	if err != nil {
+		return Recipient{}, err
+	}
+	email, err := user.OpenEmail(s.keys, p)
+	if err != nil {
+		return Recipient{}, err
+	}
+	webhook, err := queries.GetNotificationWebhook(ctx, pid)
+	if err != nil && !storage.NoRows(err) {
+		return Recipient{}, err
+	}
+	return Recipient{
+		PID:     p.ID,
+		Handle:  p.Handle,
+		Email:   email,
+		Webhook: webhook,
+	}, nil
+}
+
+// reachable avoids queueing deliveries that can never succeed, like emails to users that have not
+// verified an address yet.
+func reachable(channel string, to Recipient) bool {
+	switch channel {
+	case ChannelEmail:
+		return to.Email != ""
+	case ChannelWebhook:
+		return to.Webhook != ""
+	default:
+		return true
+	}
+}
+
+func newID(now time.Time) (string, error) {
+	id, err := ulid.New(uint64(now.UnixMilli()), rand.Reader)
+	if err != nil {
+		return "", fmt.Errorf("error creating notification id: %w", err)
+	}
+	return id.String(), nil
+}
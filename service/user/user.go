@@ -7,26 +7,58 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"log"
 	"runtime"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/avalonbits/echo-template-service/storage"
 	"github.com/avalonbits/echo-template-service/storage/datastore"
+	"github.com/avalonbits/echo-template-service/storage/jobs"
+	"github.com/avalonbits/echo-template-service/storage/keyring"
 	"github.com/oklog/ulid"
 	"golang.org/x/crypto/argon2"
 )
 
+var (
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrHandleTaken        = errors.New("username already in use")
+	ErrEmailTaken         = errors.New("email already in use")
+)
+
+const (
+	reencryptJob   = "user.reencrypt"
+	reencryptBatch = 100
+)
+
 type Service struct {
 	db          *storage.DB[datastore.Queries]
+	keys        *keyring.Keyring
 	personCache *sync.Map
 }
 
-func New(db *storage.DB[datastore.Queries]) *Service {
-	return &Service{
+// New returns the user service. Emails are sealed with keys, and the re-encryption job registered
+// with runner is queued on every start, so emails still sealed with an older key (or not sealed at
+// all) are moved to the current one after a rotation.
+func New(
+	db *storage.DB[datastore.Queries],
+	keys *keyring.Keyring,
+	runner *jobs.Runner,
+) (*Service, error) {
+	s := &Service{
 		db:          db,
+		keys:        keys,
 		personCache: &sync.Map{},
 	}
+	runner.Register(reencryptJob, s.reencrypt, jobs.Timeout(time.Hour))
+	err := db.Write(context.Background(), func(queries *datastore.Queries) error {
+		return jobs.Enqueue(context.Background(), queries, reencryptJob, nil, jobs.UniqueKey(reencryptJob))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
 }
 
 type Person struct {
@@ -34,6 +66,8 @@ type Person struct {
 	Handle string
 	Name   string
 	Email  string
+	// Language is the UI language the person picked, or "" to use the browser's.
+	Language string
 }
 
 func (s *Service) GetUser(ctx context.Context, uid string) (Person, error) {
@@ -47,18 +81,61 @@ func (s *Service) GetUser(ctx context.Context, uid string) (Person, error) {
 		p, err = queries.GetPerson(ctx, uid)
 		return err
 	})
-	return s.personFromDB(p), err
+	if err != nil {
+		return Person{}, err
+	}
+	return s.personFromDB(p)
+}
+
+// GetUserByEmail finds the person who verified email, through its blind index.
+func (s *Service) GetUserByEmail(ctx context.Context, email string) (Person, error) {
+	var p datastore.Person
+	err := s.db.Read(ctx, func(queries *datastore.Queries) error {
+		var err error
+		p, err = queries.GetPersonByEmail(ctx, datastore.GetPersonByEmailParams{
+			EmailIdx: s.keys.BlindIndex(email),
+			Email:    strings.TrimSpace(email),
+		})
+		return err
+	})
+	if err != nil {
+		return Person{}, err
+	}
+	return s.personFromDB(p)
 }
 
-func (s *Service) personFromDB(p datastore.Person) Person {
+func (s *Service) personFromDB(p datastore.Person) (Person, error) {
+	email, err := OpenEmail(s.keys, p)
+	if err != nil {
+		return Person{}, err
+	}
 	res := Person{
 		ID:     p.ID,
 		Handle: p.Handle,
 		Name:   p.DisplayName.String,
-		Email:  p.Email.String,
+		Email:  email,
+
+		Language: p.Language,
 	}
 	v, _ := s.personCache.LoadOrStore(p.ID, res)
-	return v.(Person)
+	return v.(Person), nil
+}
+
+// OpenEmail returns the email of p, decrypting it with keys. Emails the re-encryption job has not
+// sealed yet are returned as they are.
+func OpenEmail(keys *keyring.Keyring, p datastore.Person) (string, error) {
+	if p.EmailEnc == nil {
+		return p.Email.String, nil
+	}
+	email, err := keys.OpenString(p.EmailEnc, emailAAD(p.ID))
+	if err != nil {
+		return "", fmt.Errorf("error decrypting email of %s: %w", p.ID, err)
+	}
+	return email, nil
+}
+
+func emailAAD(pid string) []byte {
+	return keyring.AAD("Person", "email", pid)
 }
 
 func (s *Service) Signin(ctx context.Context, handle, password string) (Person, error) {
@@ -68,7 +145,7 @@ func (s *Service) Signin(ctx context.Context, handle, password string) (Person,
 		p, err = queries.GetPersonByHandle(ctx, handle)
 		if err != nil {
 			if storage.NoRows(err) {
-				return fmt.Errorf("invalid user")
+				return ErrInvalidCredentials
 			}
 			return err
 		}
@@ -79,10 +156,10 @@ func (s *Service) Signin(ctx context.Context, handle, password string) (Person,
 	}
 
 	if !check(password, p.Password, p.Salt) {
-		return Person{}, fmt.Errorf("invalid password")
+		return Person{}, ErrInvalidCredentials
 	}
 
-	return s.personFromDB(p), nil
+	return s.personFromDB(p)
 }
 
 func (s *Service) Signup(ctx context.Context, handle, password string) (string, error) {
@@ -97,7 +174,7 @@ func (s *Service) Signup(ctx context.Context, handle, password string) (string,
 	return uid.String(), s.db.Write(ctx, func(queries *datastore.Queries) error {
 		_, err := queries.IsRegistered(ctx, handle)
 		if err == nil {
-			return fmt.Errorf("username already in use")
+			return ErrHandleTaken
 		}
 		if !storage.NoRows(err) {
 			return err
@@ -118,9 +195,49 @@ func (s *Service) Signup(ctx context.Context, handle, password string) (string,
 	})
 }
 
+// Delete moves the account uid to the trash. It can no longer sign in, and is purged for good
+// unless an admin restores it within the trash retention period.
+func (s *Service) Delete(ctx context.Context, uid string) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	err := s.db.Write(ctx, func(queries *datastore.Queries) error {
+		n, err := queries.SoftDeletePerson(ctx, datastore.SoftDeletePersonParams{
+			DeletedAt: sql.NullString{String: now, Valid: true},
+			ID:        uid,
+		})
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return sql.ErrNoRows
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.personCache.Delete(uid)
+	return nil
+}
+
+// SetLanguage sets the UI language of uid. An empty lang goes back to the browser's language.
+func (s *Service) SetLanguage(ctx context.Context, uid, lang string) error {
+	err := s.db.Write(ctx, func(queries *datastore.Queries) error {
+		return queries.SetPersonLanguage(ctx, datastore.SetPersonLanguageParams{
+			Language: lang,
+			ID:       uid,
+		})
+	})
+	if err != nil {
+		return err
+	}
+	s.personCache.Delete(uid)
+	return nil
+}
+
 func (s *Service) ValidateToken(ctx context.Context, uid, tk string) error {
 	now := time.Now().UTC().Format(time.RFC3339)
-	return s.db.Read(ctx, func(queries *datastore.Queries) error {
+	var p Person
+	err := s.db.Write(ctx, func(queries *datastore.Queries) error {
 		regTk, err := queries.GetToken(ctx, datastore.GetTokenParams{
 			Pid:     uid,
 			Expires: now,
@@ -132,25 +249,104 @@ func (s *Service) ValidateToken(ctx context.Context, uid, tk string) error {
 			return err
 		}
 
-		// Token validated, remove it from table and update user email.
+		// Token validated, remove it from table and update user email. The unique index on email_idx
+		// misses the addresses user.reencrypt has not sealed yet, so check those too.
+		_, err = queries.IsEmailRegistered(ctx, datastore.IsEmailRegisteredParams{
+			EmailIdx: s.keys.BlindIndex(regTk.Email),
+			Email:    strings.TrimSpace(regTk.Email),
+			ID:       uid,
+		})
+		if err == nil {
+			return ErrEmailTaken
+		}
+		if !storage.NoRows(err) {
+			return err
+		}
 		if err := queries.DeleteToken(ctx, uid); err != nil {
 			return err
 		}
+		sealed, err := s.keys.SealString(regTk.Email, emailAAD(uid))
+		if err != nil {
+			return err
+		}
 		u, err := queries.SetPersonEmail(ctx, datastore.SetPersonEmailParams{
-			Email: sql.NullString{String: regTk.Email, Valid: true},
-			ID:    uid,
+			EmailEnc: sealed,
+			EmailIdx: s.keys.BlindIndex(regTk.Email),
+			ID:       uid,
 		})
 		if err != nil {
 			return err
 		}
-		s.personCache.Store(u.ID, Person{
-			ID:     u.ID,
-			Handle: u.Handle,
-			Name:   u.DisplayName.String,
-			Email:  u.Email.String,
-		})
+		p = Person{
+			ID:       u.ID,
+			Handle:   u.Handle,
+			Name:     u.DisplayName.String,
+			Email:    regTk.Email,
+			Language: u.Language,
+		}
 		return nil
 	})
+	if err != nil {
+		return err
+	}
+	// Only cache the new email once it is committed.
+	s.personCache.Store(p.ID, p)
+	return nil
+}
+
+// reencrypt seals the emails stored in plaintext and rewraps the ones sealed with an older key, a
+// batch per transaction. Rewrapping does not change the email nor its blind index.
+func (s *Service) reencrypt(ctx context.Context, _ jobs.Job) error {
+	after, updated := "", 0
+	for {
+		var people []datastore.Person
+		n := 0
+		err := s.db.Write(ctx, func(queries *datastore.Queries) error {
+			n = 0
+			var err error
+			people, err = queries.ListPeopleWithEmail(ctx, datastore.ListPeopleWithEmailParams{
+				ID:    after,
+				Limit: reencryptBatch,
+			})
+			if err != nil {
+				return err
+			}
+
+			for _, p := range people {
+				params := datastore.SetPersonEmailParams{EmailIdx: p.EmailIdx, ID: p.ID}
+				switch {
+				case p.EmailEnc == nil:
+					params.EmailEnc, err = s.keys.SealString(p.Email.String, emailAAD(p.ID))
+					params.EmailIdx = s.keys.BlindIndex(p.Email.String)
+				case !s.keys.Current(p.EmailEnc):
+					params.EmailEnc, err = s.keys.Rewrap(p.EmailEnc)
+				default:
+					continue
+				}
+				if err != nil {
+					return fmt.Errorf("error re-encrypting email of %s: %w", p.ID, err)
+				}
+				if _, err := queries.SetPersonEmail(ctx, params); err != nil {
+					return err
+				}
+				n++
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		updated += n
+		if len(people) < reencryptBatch {
+			break
+		}
+		after = people[len(people)-1].ID
+	}
+
+	if updated > 0 {
+		log.Printf("re-encrypted %d emails", updated)
+	}
+	return nil
 }
 
 func hashPassword(str string) ([]byte, []byte, error) {
@@ -0,0 +1,87 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/avalonbits/echo-template-service/storage"
+	"github.com/avalonbits/echo-template-service/storage/datastore"
+	"github.com/avalonbits/echo-template-service/storage/jobs"
+	"github.com/avalonbits/echo-template-service/storage/keyring"
+)
+
+func testService(t *testing.T) (*Service, *storage.DB[datastore.Queries]) {
+	t.Helper()
+	key, err := keyring.NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := keyring.Load([]byte(`{"current": "k1", "keys": {"k1": "` + key + `"}, "index": "` + key + `"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := storage.TestDB(datastore.Migrations, datastore.Factory)
+	t.Cleanup(func() { db.Close() })
+	s, err := New(db, keys, jobs.New(db))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, db
+}
+
+// requestEmail signs up handle and leaves a verification token for email.
+func requestEmail(t *testing.T, s *Service, db *storage.DB[datastore.Queries], handle, email string) string {
+	t.Helper()
+	ctx := context.Background()
+	uid, err := s.Signup(ctx, handle, "password123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expires := time.Now().UTC().Add(time.Hour).Format(time.RFC3339)
+	err = db.Write(ctx, func(queries *datastore.Queries) error {
+		return queries.SetRegistrationToken(ctx, datastore.SetRegistrationTokenParams{
+			Pid:     uid,
+			Email:   email,
+			Token:   "token-" + handle,
+			Expires: expires,
+			Refresh: expires,
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return uid
+}
+
+func TestLegacyEmailBeforeReencryption(t *testing.T) {
+	s, db := testService(t)
+	ctx := context.Background()
+
+	// An address from before emails were sealed, which the re-encryption job has not moved yet.
+	_, err := db.RDBMS().Exec(`INSERT INTO Person (id, handle, password, salt, created_at, email)
+		VALUES ('legacy', 'legacy', x'00', x'00', '2024-01-01T00:00:00Z', 'Legacy@Example.com')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := s.GetUserByEmail(ctx, " legacy@example.com")
+	if err != nil || p.ID != "legacy" {
+		t.Errorf("GetUserByEmail = %+v, %v; want the legacy person", p, err)
+	}
+
+	uid := requestEmail(t, s, db, "bob", "legacy@example.com")
+	if err := s.ValidateToken(ctx, uid, "token-bob"); !errors.Is(err, ErrEmailTaken) {
+		t.Errorf("ValidateToken of a legacy address = %v, want ErrEmailTaken", err)
+	}
+
+	uid = requestEmail(t, s, db, "carol", "carol@example.com")
+	if err := s.ValidateToken(ctx, uid, "token-carol"); err != nil {
+		t.Fatal(err)
+	}
+	if p, err := s.GetUserByEmail(ctx, "Carol@example.com"); err != nil || p.ID != uid {
+		t.Errorf("GetUserByEmail = %+v, %v; want carol", p, err)
+	}
+}
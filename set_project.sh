@@ -19,10 +19,13 @@ go mod tidy -v
 sqlc generate
 go get -u ./...
 
+KEYRING="{\"current\":\"k1\",\"keys\":{\"k1\":\"$(openssl rand -base64 32)\"},\"index\":\"$(openssl rand -base64 32)\"}"
+
 cat > ./.env<< EOF
 DATABASE="/tmp/$1-main.db"
 DOMAIN_NAME="localhost"
 PORT=1323
 BIND_ADDRESS="0.0.0.0"
 RECAPTCHA_TOKEN=""
+KEYRING='$KEYRING'
 EOF
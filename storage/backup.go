@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+var ErrNotSQLite = errors.New("operation is only supported on SQLite databases")
+
+// Backup writes a consistent snapshot of the database to dst using SQLite's online backup API.
+// It only holds a read transaction, so it is safe to run while the server is writing. The
+// snapshot is integrity checked before it replaces dst.
+func (db *DB[Queries]) Backup(ctx context.Context, dst string) error {
+	if db.dialect != SQLite {
+		return ErrNotSQLite
+	}
+
+	tmp := dst + ".tmp"
+	if err := backup(ctx, db.rddb, tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := VerifyIntegrity(ctx, tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
+
+// Restore replaces the SQLite database at dst with the backup at src, after checking that src is
+// intact. The previous database, along with its WAL, is kept next to dst with a .pre-restore
+// suffix. Nothing may have dst open while it runs.
+func Restore(ctx context.Context, src, dst string) error {
+	if isPostgres(dst) {
+		return ErrNotSQLite
+	}
+	if err := VerifyIntegrity(ctx, src); err != nil {
+		return err
+	}
+
+	srcDB, err := sql.Open("sqlite3", src)
+	if err != nil {
+		return err
+	}
+	defer srcDB.Close()
+
+	// Copy through the backup API rather than the file system so a -wal next to src is included.
+	tmp := dst + ".restore"
+	if err := backup(ctx, srcDB, tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := VerifyIntegrity(ctx, tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return swap(tmp, dst)
+}
+
+// swap moves the SQLite database at dst, along with its WAL, out of the way to a .pre-restore
+// name and replaces it with src.
+func swap(src, dst string) error {
+	old := fmt.Sprintf("%s.pre-restore-%s", dst, time.Now().UTC().Format("20060102T150405Z"))
+	for _, suffix := range []string{"", "-wal", "-shm"} {
+		err := os.Rename(dst+suffix, old+suffix)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return os.Rename(src, dst)
+}
+
+// VerifyIntegrity runs PRAGMA integrity_check on the SQLite database at path.
+func VerifyIntegrity(ctx context.Context, path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	rows, err := db.QueryContext(ctx, "PRAGMA integrity_check")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var problems []string
+	for rows.Next() {
+		var msg string
+		if err := rows.Scan(&msg); err != nil {
+			return err
+		}
+		if msg != "ok" {
+			problems = append(problems, msg)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s failed integrity check: %s", path, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func backup(ctx context.Context, src *sql.DB, dst string) error {
+	srcConn, err := src.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+
+	dstDB, err := sql.Open("sqlite3", dst)
+	if err != nil {
+		return err
+	}
+	defer dstDB.Close()
+	dstConn, err := dstDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer dstConn.Close()
+
+	return dstConn.Raw(func(dc any) error {
+		return srcConn.Raw(func(sc any) error {
+			bk, err := dc.(*sqlite3.SQLiteConn).Backup("main", sc.(*sqlite3.SQLiteConn), "main")
+			if err != nil {
+				return err
+			}
+
+			// Copy everything in a single step: the source is read inside one transaction, which in
+			// WAL mode does not block writers, and we never have to restart because of them.
+			done, err := bk.Step(-1)
+			if err == nil && !done {
+				err = errors.New("backup step did not copy the whole database")
+			}
+			return errors.Join(err, bk.Finish())
+		})
+	})
+}
@@ -0,0 +1,63 @@
+package storage_test
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/avalonbits/echo-template-service/storage"
+	"github.com/avalonbits/echo-template-service/storage/datastore"
+)
+
+func TestBackupDoesNotMigrate(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src.db")
+	dst := filepath.Join(dir, "backup.db")
+
+	raw, err := sql.Open("sqlite3", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = raw.Exec("CREATE TABLE Legacy(id INTEGER PRIMARY KEY); INSERT INTO Legacy VALUES (1), (2)")
+	raw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := storage.GetDB(src, datastore.Migrations, datastore.Factory, storage.SkipMigrations())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Backup(ctx, dst); err != nil {
+		db.Close()
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{src, dst} {
+		check, err := sql.Open("sqlite3", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rows, migrated int
+		err = check.QueryRow("SELECT count(*) FROM Legacy").Scan(&rows)
+		if err == nil {
+			err = check.QueryRow(
+				"SELECT count(*) FROM sqlite_master WHERE name = 'goose_db_version'").Scan(&migrated)
+		}
+		check.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if rows != 2 {
+			t.Errorf("%s has %d rows, want 2", path, rows)
+		}
+		if migrated != 0 {
+			t.Errorf("%s was migrated", path)
+		}
+	}
+}
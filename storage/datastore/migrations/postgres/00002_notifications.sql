@@ -0,0 +1,37 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS Notification(
+    id         TEXT NOT NULL PRIMARY KEY,
+    pid        TEXT NOT NULL REFERENCES Person(id) ON DELETE CASCADE,
+    event      TEXT NOT NULL,
+    title      TEXT NOT NULL,
+    body       TEXT NOT NULL,
+    inapp      BOOLEAN NOT NULL,
+    created_at TEXT NOT NULL,
+    read_at    TEXT
+);
+CREATE INDEX IF NOT EXISTS ntf_pid_idx ON Notification(pid, created_at);
+CREATE INDEX IF NOT EXISTS ntf_unread_idx ON Notification(pid) WHERE inapp AND read_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS NotificationPreference(
+    pid     TEXT NOT NULL REFERENCES Person(id) ON DELETE CASCADE,
+    event   TEXT NOT NULL,
+    channel TEXT NOT NULL,
+    enabled BOOLEAN NOT NULL,
+    PRIMARY KEY (pid, event, channel)
+);
+
+CREATE TABLE IF NOT EXISTS NotificationWebhook(
+    pid TEXT NOT NULL PRIMARY KEY REFERENCES Person(id) ON DELETE CASCADE,
+    url TEXT NOT NULL
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS NotificationWebhook;
+DROP TABLE IF EXISTS NotificationPreference;
+DROP INDEX IF EXISTS ntf_unread_idx;
+DROP INDEX IF EXISTS ntf_pid_idx;
+DROP TABLE IF EXISTS Notification;
+-- +goose StatementEnd
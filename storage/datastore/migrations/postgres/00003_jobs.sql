@@ -0,0 +1,29 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS Job(
+    id           TEXT NOT NULL PRIMARY KEY,
+    kind         TEXT NOT NULL,
+    payload      BYTEA NOT NULL,
+    status       TEXT NOT NULL,
+    attempts     BIGINT NOT NULL,
+    max_attempts BIGINT NOT NULL,
+    run_at       TEXT NOT NULL,
+    unique_key   TEXT,
+    locked_until TEXT,
+    last_error   TEXT,
+    created_at   TEXT NOT NULL,
+    finished_at  TEXT
+);
+CREATE INDEX IF NOT EXISTS job_due_idx ON Job(kind, run_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS job_status_idx ON Job(status, finished_at);
+CREATE UNIQUE INDEX IF NOT EXISTS job_unique_idx ON Job(unique_key)
+    WHERE unique_key IS NOT NULL AND status IN ('pending', 'running');
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS job_unique_idx;
+DROP INDEX IF EXISTS job_status_idx;
+DROP INDEX IF EXISTS job_due_idx;
+DROP TABLE IF EXISTS Job;
+-- +goose StatementEnd
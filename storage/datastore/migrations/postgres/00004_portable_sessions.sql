@@ -0,0 +1,10 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Postgres sessions always stored expiry as unix seconds. Kept so versions match SQLite's.
+SELECT 1;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 1;
+-- +goose StatementEnd
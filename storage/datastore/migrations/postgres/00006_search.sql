@@ -0,0 +1,10 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Full-text search uses SQLite's FTS5 and is not available on Postgres.
+SELECT 1;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 1;
+-- +goose StatementEnd
@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Emails are sealed with the keyring into email_enc. email_idx is a blind index of the address
+-- (a keyed hash) so people can still be looked up by email. The plaintext email column is only
+-- kept until the user.reencrypt job has moved existing addresses out of it.
+ALTER TABLE Person ADD COLUMN email_enc BYTEA;
+ALTER TABLE Person ADD COLUMN email_idx BYTEA;
+CREATE UNIQUE INDEX IF NOT EXISTS ppl_email_bidx ON Person(email_idx) WHERE email_idx IS NOT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+-- Addresses already moved to email_enc are lost: run this only before encrypting them.
+DROP INDEX IF EXISTS ppl_email_bidx;
+ALTER TABLE Person DROP COLUMN email_idx;
+ALTER TABLE Person DROP COLUMN email_enc;
+-- +goose StatementEnd
@@ -0,0 +1,10 @@
+-- +goose Up
+-- +goose StatementBegin
+-- language is the UI language the person picked, or '' to negotiate it from the browser.
+ALTER TABLE Person ADD COLUMN language TEXT NOT NULL DEFAULT '';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE Person DROP COLUMN language;
+-- +goose StatementEnd
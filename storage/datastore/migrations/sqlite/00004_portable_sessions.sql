@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Session expiry used to be a julian day (sqlite3store's format). It is now unix seconds, which
+-- both SQLite and Postgres can compare without dialect specific functions.
+UPDATE sessions SET expiry = (expiry - 2440587.5) * 86400.0;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+UPDATE sessions SET expiry = expiry / 86400.0 + 2440587.5;
+-- +goose StatementEnd
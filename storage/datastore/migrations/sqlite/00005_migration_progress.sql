@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Progress of the batched Go migrations that are still running, so they can resume where they
+-- stopped.
+CREATE TABLE IF NOT EXISTS MigrationProgress(
+    version     INTEGER NOT NULL PRIMARY KEY,
+    next_cursor TEXT NOT NULL,
+    processed   INTEGER NOT NULL,
+    updated_at  TEXT NOT NULL
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS MigrationProgress;
+-- +goose StatementEnd
@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Full-text search indexes are created by storage/search, and only by binaries built with FTS5,
+-- so that databases can be migrated by binaries built without it.
+SELECT 1;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 1;
+-- +goose StatementEnd
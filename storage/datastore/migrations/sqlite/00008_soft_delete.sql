@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Soft deleted rows have deleted_at set. Queries skip them unless they are about the trash, and
+-- the trash.purge job deletes them for good once retention is over.
+ALTER TABLE Person ADD COLUMN deleted_at TEXT;
+CREATE INDEX IF NOT EXISTS ppl_deleted_idx ON Person(deleted_at) WHERE deleted_at IS NOT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS ppl_deleted_idx;
+ALTER TABLE Person DROP COLUMN deleted_at;
+-- +goose StatementEnd
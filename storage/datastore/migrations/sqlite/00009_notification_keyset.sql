@@ -0,0 +1,10 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Notifications are paginated by id, a ULID that sorts by creation time.
+CREATE INDEX IF NOT EXISTS ntf_pid_id_idx ON Notification(pid, id) WHERE inapp;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS ntf_pid_id_idx;
+-- +goose StatementEnd
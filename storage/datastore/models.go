@@ -8,6 +8,51 @@ import (
 	"database/sql"
 )
 
+type Job struct {
+	ID          string
+	Kind        string
+	Payload     []byte
+	Status      string
+	Attempts    int64
+	MaxAttempts int64
+	RunAt       string
+	UniqueKey   sql.NullString
+	LockedUntil sql.NullString
+	LastError   sql.NullString
+	CreatedAt   string
+	FinishedAt  sql.NullString
+}
+
+type MigrationProgress struct {
+	Version    int64
+	NextCursor string
+	Processed  int64
+	UpdatedAt  string
+}
+
+type Notification struct {
+	ID        string
+	Pid       string
+	Event     string
+	Title     string
+	Body      string
+	Inapp     bool
+	CreatedAt string
+	ReadAt    sql.NullString
+}
+
+type NotificationPreference struct {
+	Pid     string
+	Event   string
+	Channel string
+	Enabled bool
+}
+
+type NotificationWebhook struct {
+	Pid string
+	Url string
+}
+
 type Person struct {
 	ID          string
 	Handle      string
@@ -16,6 +61,10 @@ type Person struct {
 	CreatedAt   string
 	DisplayName sql.NullString
 	Email       sql.NullString
+	EmailEnc    []byte
+	EmailIdx    []byte
+	DeletedAt   sql.NullString
+	Language    string
 }
 
 type RegistrationToken struct {
@@ -1,29 +1,38 @@
 
 -- name: GetPerson :one
-SELECT  * FROM Person WHERE id = ? LIMIT 1;
+SELECT  * FROM Person WHERE id = ? AND deleted_at IS NULL LIMIT 1;
 
 -- name: GetPersonByEmail :one
-SELECT * FROM Person WHERE email = ? LIMIT 1;
+-- Addresses the user.reencrypt job has not sealed yet are only in email, without an email_idx.
+SELECT * FROM Person
+WHERE (email_idx = sqlc.arg(email_idx) OR lower(email) = lower(sqlc.arg(email))) AND deleted_at IS NULL
+LIMIT 1;
 
 -- name: GetPersonByHandle :one
-SELECT * FROM Person WHERE handle = ? LIMIT 1;
+SELECT * FROM Person WHERE handle = ? AND deleted_at IS NULL LIMIT 1;
 
 -- name: SetPersonEmail :one
-UPDATE Person SET email = ? WHERE id = ? RETURNING *;
+UPDATE Person SET email = NULL, email_enc = ?, email_idx = ? WHERE id = ? RETURNING *;
 
 -- name: IsVerified :one
-SELECT 1 = 1 from Person WHERE handle = ? AND email IS NOT NULL;
+SELECT 1 = 1 from Person WHERE handle = ? AND (email_enc IS NOT NULL OR email IS NOT NULL)
+    AND deleted_at IS NULL;
 
 -- name: IsRegistered :one
+-- Deleted people keep their handle until they are purged.
 SELECT 1 = 1 FROM Person WHERE handle = ? LIMIT 1;
 
 -- name: IsEmailRegistered :one
-SELECT 1 = 1 FROM Person WHERE email = ? LIMIT 1;
+-- Like GetPersonByEmail, but for someone other than id, and counting deleted people who keep their
+-- email until they are purged.
+SELECT 1 = 1 FROM Person
+WHERE (email_idx = sqlc.arg(email_idx) OR lower(email) = lower(sqlc.arg(email))) AND id != sqlc.arg(id)
+LIMIT 1;
 
 -- name: SetRegistrationToken :exec
 INSERT INTO RegistrationToken (pid, email, token, expires, refresh)
        VALUES (?, ?, ?, ?, ?)
-ON CONFLICT DO UPDATE SET
+ON CONFLICT(pid) DO UPDATE SET
     email = excluded.email,
     token = excluded.token,
     expires = excluded.expires,
@@ -35,9 +44,162 @@ SELECT * FROM RegistrationToken WHERE pid = ? AND expires > ?;
 -- name: DeleteToken :exec
 DELETE FROM RegistrationToken WHERE pid = ?;
 
--- name: DeleteExpiredTokens :exec
-DELETE from RegistrationToken WHERE expires >= ?;
+-- name: DeleteExpiredTokens :execrows
+DELETE FROM RegistrationToken WHERE expires < ?;
+
+-- name: DeleteExpiredSessions :execrows
+DELETE FROM sessions WHERE expiry < ?;
+
+-- name: FindSession :one
+SELECT data FROM sessions WHERE token = ? AND expiry > ?;
+
+-- name: CommitSession :exec
+INSERT INTO sessions(token, data, expiry) VALUES (?, ?, ?)
+ON CONFLICT(token) DO UPDATE SET data = excluded.data, expiry = excluded.expiry;
+
+-- name: DeleteSession :exec
+DELETE FROM sessions WHERE token = ?;
 
 -- name: CreateUser :exec
 INSERT INTO Person(id, handle, created_at, password, salt)
        VALUES (?, ?, ?, ?,?);
+
+-- name: CreateNotification :exec
+INSERT INTO Notification(id, pid, event, title, body, inapp, created_at)
+       VALUES (?, ?, ?, ?, ?, ?, ?);
+
+-- name: ListNotifications :many
+SELECT * FROM Notification
+WHERE pid = ? AND inapp AND (sqlc.arg(after) = '' OR id < sqlc.arg(after))
+ORDER BY id DESC
+LIMIT ?;
+
+-- name: ListNotificationsBefore :many
+SELECT * FROM Notification
+WHERE pid = ? AND inapp AND id > sqlc.arg(before)
+ORDER BY id
+LIMIT ?;
+
+-- name: CountUnreadNotifications :one
+SELECT COUNT(*) FROM Notification WHERE pid = ? AND inapp AND read_at IS NULL;
+
+-- name: MarkNotificationsRead :exec
+UPDATE Notification SET read_at = ? WHERE pid = ? AND read_at IS NULL;
+
+-- name: GetNotification :one
+SELECT * FROM Notification WHERE id = ?;
+
+-- name: DeleteReadNotifications :execrows
+DELETE FROM Notification WHERE read_at < ?;
+
+-- name: GetNotificationPreferences :many
+SELECT * FROM NotificationPreference WHERE pid = ?;
+
+-- name: SetNotificationPreference :exec
+INSERT INTO NotificationPreference(pid, event, channel, enabled)
+       VALUES (?, ?, ?, ?)
+ON CONFLICT(pid, event, channel) DO UPDATE SET enabled = excluded.enabled;
+
+-- name: GetNotificationWebhook :one
+SELECT url FROM NotificationWebhook WHERE pid = ?;
+
+-- name: SetNotificationWebhook :exec
+INSERT INTO NotificationWebhook(pid, url) VALUES (?, ?)
+ON CONFLICT(pid) DO UPDATE SET url = excluded.url;
+
+-- name: DeleteNotificationWebhook :exec
+DELETE FROM NotificationWebhook WHERE pid = ?;
+
+-- name: EnqueueJob :exec
+INSERT INTO Job(id, kind, payload, status, attempts, max_attempts, run_at, unique_key, created_at)
+       VALUES (?, ?, ?, 'pending', 0, ?, ?, ?, ?)
+ON CONFLICT DO NOTHING;
+
+-- name: ClaimJobs :many
+UPDATE Job SET status = 'running', attempts = attempts + 1, locked_until = ?
+ WHERE status = 'pending' AND id IN (
+     SELECT id FROM Job WHERE kind = ? AND status = 'pending' AND run_at <= ?
+      ORDER BY run_at LIMIT ?)
+RETURNING *;
+
+-- name: DueJobKinds :many
+SELECT DISTINCT kind FROM Job WHERE status = 'pending' AND run_at <= ?;
+
+-- name: CompleteJob :exec
+UPDATE Job SET status = 'done', locked_until = NULL, last_error = NULL, finished_at = ?
+ WHERE id = ?;
+
+-- name: RetryJob :exec
+UPDATE Job SET status = 'pending', locked_until = NULL, last_error = ?, run_at = ? WHERE id = ?;
+
+-- name: BuryJob :exec
+UPDATE Job SET status = 'dead', locked_until = NULL, last_error = ?, finished_at = ? WHERE id = ?;
+
+-- name: ReleaseJob :exec
+UPDATE Job SET status = 'pending', attempts = attempts - 1, locked_until = NULL WHERE id = ?;
+
+-- name: RequeueStaleJobs :execrows
+UPDATE Job SET status = 'pending', locked_until = NULL
+ WHERE status = 'running' AND locked_until < ?;
+
+-- name: ListDeadJobs :many
+SELECT * FROM Job WHERE status = 'dead' ORDER BY finished_at DESC LIMIT ?;
+
+-- name: ReviveJob :execrows
+UPDATE Job SET status = 'pending', attempts = 0, run_at = ?, finished_at = NULL
+ WHERE id = ? AND status = 'dead';
+
+-- name: DeleteFinishedJobs :execrows
+DELETE FROM Job WHERE status = ? AND finished_at < ?;
+
+-- name: SearchPeople :many
+SELECT Person.id, Person.handle, Person.display_name,
+       snippet(PersonSearch, -1, char(2), char(3), '…', 8) AS snippet,
+       bm25(PersonSearch, 10.0, 5.0) AS rank
+  FROM PersonSearch JOIN Person ON Person.rowid = PersonSearch.rowid
+ WHERE PersonSearch MATCH ? AND Person.deleted_at IS NULL
+ ORDER BY rank LIMIT ?;
+
+-- name: SearchNotifications :many
+SELECT Notification.id, Notification.title, Notification.created_at,
+       snippet(NotificationSearch, -1, char(2), char(3), '…', 12) AS snippet,
+       bm25(NotificationSearch, 5.0, 1.0) AS rank
+  FROM NotificationSearch JOIN Notification ON Notification.rowid = NotificationSearch.rowid
+ WHERE NotificationSearch MATCH ? AND Notification.pid = ? AND Notification.inapp
+ ORDER BY rank LIMIT ?;
+
+-- name: ListPeopleWithEmail :many
+SELECT * FROM Person
+WHERE id > ? AND (email IS NOT NULL OR email_enc IS NOT NULL)
+ORDER BY id
+LIMIT ?;
+
+-- name: SoftDeletePerson :execrows
+UPDATE Person SET deleted_at = ? WHERE id = ? AND deleted_at IS NULL;
+
+-- name: RestorePerson :execrows
+UPDATE Person SET deleted_at = NULL WHERE id = ? AND deleted_at >= ?;
+
+-- name: ListDeletedPeople :many
+SELECT id, handle, display_name, deleted_at FROM Person
+WHERE deleted_at IS NOT NULL
+ORDER BY deleted_at DESC
+LIMIT ?;
+
+-- name: PurgeDeletedPeopleNotifications :execrows
+DELETE FROM Notification WHERE pid IN (SELECT id FROM Person WHERE deleted_at < ?);
+
+-- name: PurgeDeletedPeoplePreferences :execrows
+DELETE FROM NotificationPreference WHERE pid IN (SELECT id FROM Person WHERE deleted_at < ?);
+
+-- name: PurgeDeletedPeopleWebhooks :execrows
+DELETE FROM NotificationWebhook WHERE pid IN (SELECT id FROM Person WHERE deleted_at < ?);
+
+-- name: PurgeDeletedPeopleTokens :execrows
+DELETE FROM RegistrationToken WHERE pid IN (SELECT id FROM Person WHERE deleted_at < ?);
+
+-- name: PurgeDeletedPeople :execrows
+DELETE FROM Person WHERE deleted_at < ?;
+
+-- name: SetPersonLanguage :exec
+UPDATE Person SET language = ? WHERE id = ?;
@@ -10,6 +10,147 @@ import (
 	"database/sql"
 )
 
+const buryJob = `-- name: BuryJob :exec
+UPDATE Job SET status = 'dead', locked_until = NULL, last_error = ?, finished_at = ? WHERE id = ?
+`
+
+type BuryJobParams struct {
+	LastError  sql.NullString
+	FinishedAt sql.NullString
+	ID         string
+}
+
+func (q *Queries) BuryJob(ctx context.Context, arg BuryJobParams) error {
+	_, err := q.db.ExecContext(ctx, buryJob, arg.LastError, arg.FinishedAt, arg.ID)
+	return err
+}
+
+const claimJobs = `-- name: ClaimJobs :many
+UPDATE Job SET status = 'running', attempts = attempts + 1, locked_until = ?
+ WHERE status = 'pending' AND id IN (
+     SELECT id FROM Job WHERE kind = ? AND status = 'pending' AND run_at <= ?
+      ORDER BY run_at LIMIT ?)
+RETURNING id, kind, payload, status, attempts, max_attempts, run_at, unique_key, locked_until, last_error, created_at, finished_at
+`
+
+type ClaimJobsParams struct {
+	LockedUntil sql.NullString
+	Kind        string
+	RunAt       string
+	Limit       int64
+}
+
+func (q *Queries) ClaimJobs(ctx context.Context, arg ClaimJobsParams) ([]Job, error) {
+	rows, err := q.db.QueryContext(ctx, claimJobs,
+		arg.LockedUntil,
+		arg.Kind,
+		arg.RunAt,
+		arg.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Job
+	for rows.Next() {
+		var i Job
+		if err := rows.Scan(
+			&i.ID,
+			&i.Kind,
+			&i.Payload,
+			&i.Status,
+			&i.Attempts,
+			&i.MaxAttempts,
+			&i.RunAt,
+			&i.UniqueKey,
+			&i.LockedUntil,
+			&i.LastError,
+			&i.CreatedAt,
+			&i.FinishedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const commitSession = `-- name: CommitSession :exec
+INSERT INTO sessions(token, data, expiry) VALUES (?, ?, ?)
+ON CONFLICT(token) DO UPDATE SET data = excluded.data, expiry = excluded.expiry
+`
+
+type CommitSessionParams struct {
+	Token  string
+	Data   []byte
+	Expiry float64
+}
+
+func (q *Queries) CommitSession(ctx context.Context, arg CommitSessionParams) error {
+	_, err := q.db.ExecContext(ctx, commitSession, arg.Token, arg.Data, arg.Expiry)
+	return err
+}
+
+const completeJob = `-- name: CompleteJob :exec
+UPDATE Job SET status = 'done', locked_until = NULL, last_error = NULL, finished_at = ?
+ WHERE id = ?
+`
+
+type CompleteJobParams struct {
+	FinishedAt sql.NullString
+	ID         string
+}
+
+func (q *Queries) CompleteJob(ctx context.Context, arg CompleteJobParams) error {
+	_, err := q.db.ExecContext(ctx, completeJob, arg.FinishedAt, arg.ID)
+	return err
+}
+
+const countUnreadNotifications = `-- name: CountUnreadNotifications :one
+SELECT COUNT(*) FROM Notification WHERE pid = ? AND inapp AND read_at IS NULL
+`
+
+func (q *Queries) CountUnreadNotifications(ctx context.Context, pid string) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countUnreadNotifications, pid)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const createNotification = `-- name: CreateNotification :exec
+INSERT INTO Notification(id, pid, event, title, body, inapp, created_at)
+       VALUES (?, ?, ?, ?, ?, ?, ?)
+`
+
+type CreateNotificationParams struct {
+	ID        string
+	Pid       string
+	Event     string
+	Title     string
+	Body      string
+	Inapp     bool
+	CreatedAt string
+}
+
+func (q *Queries) CreateNotification(ctx context.Context, arg CreateNotificationParams) error {
+	_, err := q.db.ExecContext(ctx, createNotification,
+		arg.ID,
+		arg.Pid,
+		arg.Event,
+		arg.Title,
+		arg.Body,
+		arg.Inapp,
+		arg.CreatedAt,
+	)
+	return err
+}
+
 const createUser = `-- name: CreateUser :exec
 INSERT INTO Person(id, handle, created_at, password, salt)
        VALUES (?, ?, ?, ?,?)
@@ -34,12 +175,74 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) error {
 	return err
 }
 
-const deleteExpiredTokens = `-- name: DeleteExpiredTokens :exec
-DELETE from RegistrationToken WHERE expires >= ?
+const deleteExpiredSessions = `-- name: DeleteExpiredSessions :execrows
+DELETE FROM sessions WHERE expiry < ?
+`
+
+func (q *Queries) DeleteExpiredSessions(ctx context.Context, expiry float64) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteExpiredSessions, expiry)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteExpiredTokens = `-- name: DeleteExpiredTokens :execrows
+DELETE FROM RegistrationToken WHERE expires < ?
+`
+
+func (q *Queries) DeleteExpiredTokens(ctx context.Context, expires string) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteExpiredTokens, expires)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteFinishedJobs = `-- name: DeleteFinishedJobs :execrows
+DELETE FROM Job WHERE status = ? AND finished_at < ?
+`
+
+type DeleteFinishedJobsParams struct {
+	Status     string
+	FinishedAt sql.NullString
+}
+
+func (q *Queries) DeleteFinishedJobs(ctx context.Context, arg DeleteFinishedJobsParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteFinishedJobs, arg.Status, arg.FinishedAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteNotificationWebhook = `-- name: DeleteNotificationWebhook :exec
+DELETE FROM NotificationWebhook WHERE pid = ?
+`
+
+func (q *Queries) DeleteNotificationWebhook(ctx context.Context, pid string) error {
+	_, err := q.db.ExecContext(ctx, deleteNotificationWebhook, pid)
+	return err
+}
+
+const deleteReadNotifications = `-- name: DeleteReadNotifications :execrows
+DELETE FROM Notification WHERE read_at < ?
+`
+
+func (q *Queries) DeleteReadNotifications(ctx context.Context, readAt sql.NullString) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteReadNotifications, readAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteSession = `-- name: DeleteSession :exec
+DELETE FROM sessions WHERE token = ?
 `
 
-func (q *Queries) DeleteExpiredTokens(ctx context.Context, expires string) error {
-	_, err := q.db.ExecContext(ctx, deleteExpiredTokens, expires)
+func (q *Queries) DeleteSession(ctx context.Context, token string) error {
+	_, err := q.db.ExecContext(ctx, deleteSession, token)
 	return err
 }
 
@@ -52,8 +255,143 @@ func (q *Queries) DeleteToken(ctx context.Context, pid string) error {
 	return err
 }
 
+const dueJobKinds = `-- name: DueJobKinds :many
+SELECT DISTINCT kind FROM Job WHERE status = 'pending' AND run_at <= ?
+`
+
+func (q *Queries) DueJobKinds(ctx context.Context, runAt string) ([]string, error) {
+	rows, err := q.db.QueryContext(ctx, dueJobKinds, runAt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var kind string
+		if err := rows.Scan(&kind); err != nil {
+			return nil, err
+		}
+		items = append(items, kind)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const enqueueJob = `-- name: EnqueueJob :exec
+INSERT INTO Job(id, kind, payload, status, attempts, max_attempts, run_at, unique_key, created_at)
+       VALUES (?, ?, ?, 'pending', 0, ?, ?, ?, ?)
+ON CONFLICT DO NOTHING
+`
+
+type EnqueueJobParams struct {
+	ID          string
+	Kind        string
+	Payload     []byte
+	MaxAttempts int64
+	RunAt       string
+	UniqueKey   sql.NullString
+	CreatedAt   string
+}
+
+func (q *Queries) EnqueueJob(ctx context.Context, arg EnqueueJobParams) error {
+	_, err := q.db.ExecContext(ctx, enqueueJob,
+		arg.ID,
+		arg.Kind,
+		arg.Payload,
+		arg.MaxAttempts,
+		arg.RunAt,
+		arg.UniqueKey,
+		arg.CreatedAt,
+	)
+	return err
+}
+
+const findSession = `-- name: FindSession :one
+SELECT data FROM sessions WHERE token = ? AND expiry > ?
+`
+
+type FindSessionParams struct {
+	Token  string
+	Expiry float64
+}
+
+func (q *Queries) FindSession(ctx context.Context, arg FindSessionParams) ([]byte, error) {
+	row := q.db.QueryRowContext(ctx, findSession, arg.Token, arg.Expiry)
+	var data []byte
+	err := row.Scan(&data)
+	return data, err
+}
+
+const getNotification = `-- name: GetNotification :one
+SELECT id, pid, event, title, body, inapp, created_at, read_at FROM Notification WHERE id = ?
+`
+
+func (q *Queries) GetNotification(ctx context.Context, id string) (Notification, error) {
+	row := q.db.QueryRowContext(ctx, getNotification, id)
+	var i Notification
+	err := row.Scan(
+		&i.ID,
+		&i.Pid,
+		&i.Event,
+		&i.Title,
+		&i.Body,
+		&i.Inapp,
+		&i.CreatedAt,
+		&i.ReadAt,
+	)
+	return i, err
+}
+
+const getNotificationPreferences = `-- name: GetNotificationPreferences :many
+SELECT pid, event, channel, enabled FROM NotificationPreference WHERE pid = ?
+`
+
+func (q *Queries) GetNotificationPreferences(ctx context.Context, pid string) ([]NotificationPreference, error) {
+	rows, err := q.db.QueryContext(ctx, getNotificationPreferences, pid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []NotificationPreference
+	for rows.Next() {
+		var i NotificationPreference
+		if err := rows.Scan(
+			&i.Pid,
+			&i.Event,
+			&i.Channel,
+			&i.Enabled,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getNotificationWebhook = `-- name: GetNotificationWebhook :one
+SELECT url FROM NotificationWebhook WHERE pid = ?
+`
+
+func (q *Queries) GetNotificationWebhook(ctx context.Context, pid string) (string, error) {
+	row := q.db.QueryRowContext(ctx, getNotificationWebhook, pid)
+	var url string
+	err := row.Scan(&url)
+	return url, err
+}
+
 const getPerson = `-- name: GetPerson :one
-SELECT  id, handle, password, salt, created_at, display_name, email FROM Person WHERE id = ? LIMIT 1
+SELECT  id, handle, password, salt, created_at, display_name, email, email_enc, email_idx, deleted_at, language FROM Person WHERE id = ? AND deleted_at IS NULL LIMIT 1
 `
 
 func (q *Queries) GetPerson(ctx context.Context, id string) (Person, error) {
@@ -67,16 +405,28 @@ func (q *Queries) GetPerson(ctx context.Context, id string) (Person, error) {
 		&i.CreatedAt,
 		&i.DisplayName,
 		&i.Email,
+		&i.EmailEnc,
+		&i.EmailIdx,
+		&i.DeletedAt,
+		&i.Language,
 	)
 	return i, err
 }
 
 const getPersonByEmail = `-- name: GetPersonByEmail :one
-SELECT id, handle, password, salt, created_at, display_name, email FROM Person WHERE email = ? LIMIT 1
+-- Addresses the user.reencrypt job has not sealed yet are only in email, without an email_idx.
+SELECT id, handle, password, salt, created_at, display_name, email, email_enc, email_idx, deleted_at, language FROM Person
+WHERE (email_idx = ? OR lower(email) = lower(?)) AND deleted_at IS NULL
+LIMIT 1
 `
 
-func (q *Queries) GetPersonByEmail(ctx context.Context, email sql.NullString) (Person, error) {
-	row := q.db.QueryRowContext(ctx, getPersonByEmail, email)
+type GetPersonByEmailParams struct {
+	EmailIdx []byte
+	Email    string
+}
+
+func (q *Queries) GetPersonByEmail(ctx context.Context, arg GetPersonByEmailParams) (Person, error) {
+	row := q.db.QueryRowContext(ctx, getPersonByEmail, arg.EmailIdx, arg.Email)
 	var i Person
 	err := row.Scan(
 		&i.ID,
@@ -86,12 +436,16 @@ func (q *Queries) GetPersonByEmail(ctx context.Context, email sql.NullString) (P
 		&i.CreatedAt,
 		&i.DisplayName,
 		&i.Email,
+		&i.EmailEnc,
+		&i.EmailIdx,
+		&i.DeletedAt,
+		&i.Language,
 	)
 	return i, err
 }
 
 const getPersonByHandle = `-- name: GetPersonByHandle :one
-SELECT id, handle, password, salt, created_at, display_name, email FROM Person WHERE handle = ? LIMIT 1
+SELECT id, handle, password, salt, created_at, display_name, email, email_enc, email_idx, deleted_at, language FROM Person WHERE handle = ? AND deleted_at IS NULL LIMIT 1
 `
 
 func (q *Queries) GetPersonByHandle(ctx context.Context, handle string) (Person, error) {
@@ -105,6 +459,10 @@ func (q *Queries) GetPersonByHandle(ctx context.Context, handle string) (Person,
 		&i.CreatedAt,
 		&i.DisplayName,
 		&i.Email,
+		&i.EmailEnc,
+		&i.EmailIdx,
+		&i.DeletedAt,
+		&i.Language,
 	)
 	return i, err
 }
@@ -132,17 +490,28 @@ func (q *Queries) GetToken(ctx context.Context, arg GetTokenParams) (Registratio
 }
 
 const isEmailRegistered = `-- name: IsEmailRegistered :one
-SELECT 1 = 1 FROM Person WHERE email = ? LIMIT 1
+-- Like GetPersonByEmail, but for someone other than id, and counting deleted people who keep their
+-- email until they are purged.
+SELECT 1 = 1 FROM Person
+WHERE (email_idx = ? OR lower(email) = lower(?)) AND id != ?
+LIMIT 1
 `
 
-func (q *Queries) IsEmailRegistered(ctx context.Context, email sql.NullString) (bool, error) {
-	row := q.db.QueryRowContext(ctx, isEmailRegistered, email)
+type IsEmailRegisteredParams struct {
+	EmailIdx []byte
+	Email    string
+	ID       string
+}
+
+func (q *Queries) IsEmailRegistered(ctx context.Context, arg IsEmailRegisteredParams) (bool, error) {
+	row := q.db.QueryRowContext(ctx, isEmailRegistered, arg.EmailIdx, arg.Email, arg.ID)
 	var column_1 bool
 	err := row.Scan(&column_1)
 	return column_1, err
 }
 
 const isRegistered = `-- name: IsRegistered :one
+-- Deleted people keep their handle until they are purged.
 SELECT 1 = 1 FROM Person WHERE handle = ? LIMIT 1
 `
 
@@ -154,7 +523,8 @@ func (q *Queries) IsRegistered(ctx context.Context, handle string) (bool, error)
 }
 
 const isVerified = `-- name: IsVerified :one
-SELECT 1 = 1 from Person WHERE handle = ? AND email IS NOT NULL
+SELECT 1 = 1 from Person WHERE handle = ? AND (email_enc IS NOT NULL OR email IS NOT NULL)
+    AND deleted_at IS NULL
 `
 
 func (q *Queries) IsVerified(ctx context.Context, handle string) (bool, error) {
@@ -164,17 +534,524 @@ func (q *Queries) IsVerified(ctx context.Context, handle string) (bool, error) {
 	return column_1, err
 }
 
+const listDeadJobs = `-- name: ListDeadJobs :many
+SELECT id, kind, payload, status, attempts, max_attempts, run_at, unique_key, locked_until, last_error, created_at, finished_at FROM Job WHERE status = 'dead' ORDER BY finished_at DESC LIMIT ?
+`
+
+func (q *Queries) ListDeadJobs(ctx context.Context, limit int64) ([]Job, error) {
+	rows, err := q.db.QueryContext(ctx, listDeadJobs, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Job
+	for rows.Next() {
+		var i Job
+		if err := rows.Scan(
+			&i.ID,
+			&i.Kind,
+			&i.Payload,
+			&i.Status,
+			&i.Attempts,
+			&i.MaxAttempts,
+			&i.RunAt,
+			&i.UniqueKey,
+			&i.LockedUntil,
+			&i.LastError,
+			&i.CreatedAt,
+			&i.FinishedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listDeletedPeople = `-- name: ListDeletedPeople :many
+SELECT id, handle, display_name, deleted_at FROM Person
+WHERE deleted_at IS NOT NULL
+ORDER BY deleted_at DESC
+LIMIT ?
+`
+
+type ListDeletedPeopleRow struct {
+	ID          string
+	Handle      string
+	DisplayName sql.NullString
+	DeletedAt   sql.NullString
+}
+
+func (q *Queries) ListDeletedPeople(ctx context.Context, limit int64) ([]ListDeletedPeopleRow, error) {
+	rows, err := q.db.QueryContext(ctx, listDeletedPeople, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListDeletedPeopleRow
+	for rows.Next() {
+		var i ListDeletedPeopleRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Handle,
+			&i.DisplayName,
+			&i.DeletedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listNotifications = `-- name: ListNotifications :many
+SELECT id, pid, event, title, body, inapp, created_at, read_at FROM Notification
+WHERE pid = ? AND inapp AND (? = '' OR id < ?)
+ORDER BY id DESC
+LIMIT ?
+`
+
+type ListNotificationsParams struct {
+	Pid   string
+	After string
+	Limit int64
+}
+
+func (q *Queries) ListNotifications(ctx context.Context, arg ListNotificationsParams) ([]Notification, error) {
+	rows, err := q.db.QueryContext(ctx, listNotifications, arg.Pid, arg.After, arg.After, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Notification
+	for rows.Next() {
+		var i Notification
+		if err := rows.Scan(
+			&i.ID,
+			&i.Pid,
+			&i.Event,
+			&i.Title,
+			&i.Body,
+			&i.Inapp,
+			&i.CreatedAt,
+			&i.ReadAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listNotificationsBefore = `-- name: ListNotificationsBefore :many
+SELECT id, pid, event, title, body, inapp, created_at, read_at FROM Notification
+WHERE pid = ? AND inapp AND id > ?
+ORDER BY id
+LIMIT ?
+`
+
+type ListNotificationsBeforeParams struct {
+	Pid    string
+	Before string
+	Limit  int64
+}
+
+func (q *Queries) ListNotificationsBefore(ctx context.Context, arg ListNotificationsBeforeParams) ([]Notification, error) {
+	rows, err := q.db.QueryContext(ctx, listNotificationsBefore, arg.Pid, arg.Before, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Notification
+	for rows.Next() {
+		var i Notification
+		if err := rows.Scan(
+			&i.ID,
+			&i.Pid,
+			&i.Event,
+			&i.Title,
+			&i.Body,
+			&i.Inapp,
+			&i.CreatedAt,
+			&i.ReadAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listPeopleWithEmail = `-- name: ListPeopleWithEmail :many
+SELECT id, handle, password, salt, created_at, display_name, email, email_enc, email_idx, deleted_at, language FROM Person
+WHERE id > ? AND (email IS NOT NULL OR email_enc IS NOT NULL)
+ORDER BY id
+LIMIT ?
+`
+
+type ListPeopleWithEmailParams struct {
+	ID    string
+	Limit int64
+}
+
+func (q *Queries) ListPeopleWithEmail(ctx context.Context, arg ListPeopleWithEmailParams) ([]Person, error) {
+	rows, err := q.db.QueryContext(ctx, listPeopleWithEmail, arg.ID, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Person
+	for rows.Next() {
+		var i Person
+		if err := rows.Scan(
+			&i.ID,
+			&i.Handle,
+			&i.Password,
+			&i.Salt,
+			&i.CreatedAt,
+			&i.DisplayName,
+			&i.Email,
+			&i.EmailEnc,
+			&i.EmailIdx,
+			&i.DeletedAt,
+			&i.Language,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const markNotificationsRead = `-- name: MarkNotificationsRead :exec
+UPDATE Notification SET read_at = ? WHERE pid = ? AND read_at IS NULL
+`
+
+type MarkNotificationsReadParams struct {
+	ReadAt sql.NullString
+	Pid    string
+}
+
+func (q *Queries) MarkNotificationsRead(ctx context.Context, arg MarkNotificationsReadParams) error {
+	_, err := q.db.ExecContext(ctx, markNotificationsRead, arg.ReadAt, arg.Pid)
+	return err
+}
+
+const purgeDeletedPeople = `-- name: PurgeDeletedPeople :execrows
+DELETE FROM Person WHERE deleted_at < ?
+`
+
+func (q *Queries) PurgeDeletedPeople(ctx context.Context, deletedAt sql.NullString) (int64, error) {
+	result, err := q.db.ExecContext(ctx, purgeDeletedPeople, deletedAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const purgeDeletedPeopleNotifications = `-- name: PurgeDeletedPeopleNotifications :execrows
+DELETE FROM Notification WHERE pid IN (SELECT id FROM Person WHERE deleted_at < ?)
+`
+
+func (q *Queries) PurgeDeletedPeopleNotifications(ctx context.Context, deletedAt sql.NullString) (int64, error) {
+	result, err := q.db.ExecContext(ctx, purgeDeletedPeopleNotifications, deletedAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const purgeDeletedPeoplePreferences = `-- name: PurgeDeletedPeoplePreferences :execrows
+DELETE FROM NotificationPreference WHERE pid IN (SELECT id FROM Person WHERE deleted_at < ?)
+`
+
+func (q *Queries) PurgeDeletedPeoplePreferences(ctx context.Context, deletedAt sql.NullString) (int64, error) {
+	result, err := q.db.ExecContext(ctx, purgeDeletedPeoplePreferences, deletedAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const purgeDeletedPeopleTokens = `-- name: PurgeDeletedPeopleTokens :execrows
+DELETE FROM RegistrationToken WHERE pid IN (SELECT id FROM Person WHERE deleted_at < ?)
+`
+
+func (q *Queries) PurgeDeletedPeopleTokens(ctx context.Context, deletedAt sql.NullString) (int64, error) {
+	result, err := q.db.ExecContext(ctx, purgeDeletedPeopleTokens, deletedAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const purgeDeletedPeopleWebhooks = `-- name: PurgeDeletedPeopleWebhooks :execrows
+DELETE FROM NotificationWebhook WHERE pid IN (SELECT id FROM Person WHERE deleted_at < ?)
+`
+
+func (q *Queries) PurgeDeletedPeopleWebhooks(ctx context.Context, deletedAt sql.NullString) (int64, error) {
+	result, err := q.db.ExecContext(ctx, purgeDeletedPeopleWebhooks, deletedAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const releaseJob = `-- name: ReleaseJob :exec
+UPDATE Job SET status = 'pending', attempts = attempts - 1, locked_until = NULL WHERE id = ?
+`
+
+func (q *Queries) ReleaseJob(ctx context.Context, id string) error {
+	_, err := q.db.ExecContext(ctx, releaseJob, id)
+	return err
+}
+
+const requeueStaleJobs = `-- name: RequeueStaleJobs :execrows
+UPDATE Job SET status = 'pending', locked_until = NULL
+ WHERE status = 'running' AND locked_until < ?
+`
+
+func (q *Queries) RequeueStaleJobs(ctx context.Context, lockedUntil sql.NullString) (int64, error) {
+	result, err := q.db.ExecContext(ctx, requeueStaleJobs, lockedUntil)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const restorePerson = `-- name: RestorePerson :execrows
+UPDATE Person SET deleted_at = NULL WHERE id = ? AND deleted_at >= ?
+`
+
+type RestorePersonParams struct {
+	ID        string
+	DeletedAt sql.NullString
+}
+
+func (q *Queries) RestorePerson(ctx context.Context, arg RestorePersonParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, restorePerson, arg.ID, arg.DeletedAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const retryJob = `-- name: RetryJob :exec
+UPDATE Job SET status = 'pending', locked_until = NULL, last_error = ?, run_at = ? WHERE id = ?
+`
+
+type RetryJobParams struct {
+	LastError sql.NullString
+	RunAt     string
+	ID        string
+}
+
+func (q *Queries) RetryJob(ctx context.Context, arg RetryJobParams) error {
+	_, err := q.db.ExecContext(ctx, retryJob, arg.LastError, arg.RunAt, arg.ID)
+	return err
+}
+
+const reviveJob = `-- name: ReviveJob :execrows
+UPDATE Job SET status = 'pending', attempts = 0, run_at = ?, finished_at = NULL
+ WHERE id = ? AND status = 'dead'
+`
+
+type ReviveJobParams struct {
+	RunAt string
+	ID    string
+}
+
+func (q *Queries) ReviveJob(ctx context.Context, arg ReviveJobParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, reviveJob, arg.RunAt, arg.ID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const searchNotifications = `-- name: SearchNotifications :many
+SELECT Notification.id, Notification.title, Notification.created_at,
+       snippet(NotificationSearch, -1, char(2), char(3), '…', 12) AS snippet,
+       bm25(NotificationSearch, 5.0, 1.0) AS rank
+  FROM NotificationSearch JOIN Notification ON Notification.rowid = NotificationSearch.rowid
+ WHERE NotificationSearch MATCH ? AND Notification.pid = ? AND Notification.inapp
+ ORDER BY rank LIMIT ?
+`
+
+type SearchNotificationsParams struct {
+	Query string
+	Pid   string
+	Limit int64
+}
+
+type SearchNotificationsRow struct {
+	ID        string
+	Title     string
+	CreatedAt string
+	Snippet   string
+	Rank      float64
+}
+
+func (q *Queries) SearchNotifications(ctx context.Context, arg SearchNotificationsParams) ([]SearchNotificationsRow, error) {
+	rows, err := q.db.QueryContext(ctx, searchNotifications, arg.Query, arg.Pid, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []SearchNotificationsRow
+	for rows.Next() {
+		var i SearchNotificationsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Title,
+			&i.CreatedAt,
+			&i.Snippet,
+			&i.Rank,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const searchPeople = `-- name: SearchPeople :many
+SELECT Person.id, Person.handle, Person.display_name,
+       snippet(PersonSearch, -1, char(2), char(3), '…', 8) AS snippet,
+       bm25(PersonSearch, 10.0, 5.0) AS rank
+  FROM PersonSearch JOIN Person ON Person.rowid = PersonSearch.rowid
+ WHERE PersonSearch MATCH ? AND Person.deleted_at IS NULL
+ ORDER BY rank LIMIT ?
+`
+
+type SearchPeopleParams struct {
+	Query string
+	Limit int64
+}
+
+type SearchPeopleRow struct {
+	ID          string
+	Handle      string
+	DisplayName sql.NullString
+	Snippet     string
+	Rank        float64
+}
+
+func (q *Queries) SearchPeople(ctx context.Context, arg SearchPeopleParams) ([]SearchPeopleRow, error) {
+	rows, err := q.db.QueryContext(ctx, searchPeople, arg.Query, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []SearchPeopleRow
+	for rows.Next() {
+		var i SearchPeopleRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Handle,
+			&i.DisplayName,
+			&i.Snippet,
+			&i.Rank,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const setNotificationPreference = `-- name: SetNotificationPreference :exec
+INSERT INTO NotificationPreference(pid, event, channel, enabled)
+       VALUES (?, ?, ?, ?)
+ON CONFLICT(pid, event, channel) DO UPDATE SET enabled = excluded.enabled
+`
+
+type SetNotificationPreferenceParams struct {
+	Pid     string
+	Event   string
+	Channel string
+	Enabled bool
+}
+
+func (q *Queries) SetNotificationPreference(ctx context.Context, arg SetNotificationPreferenceParams) error {
+	_, err := q.db.ExecContext(ctx, setNotificationPreference,
+		arg.Pid,
+		arg.Event,
+		arg.Channel,
+		arg.Enabled,
+	)
+	return err
+}
+
+const setNotificationWebhook = `-- name: SetNotificationWebhook :exec
+INSERT INTO NotificationWebhook(pid, url) VALUES (?, ?)
+ON CONFLICT(pid) DO UPDATE SET url = excluded.url
+`
+
+type SetNotificationWebhookParams struct {
+	Pid string
+	Url string
+}
+
+func (q *Queries) SetNotificationWebhook(ctx context.Context, arg SetNotificationWebhookParams) error {
+	_, err := q.db.ExecContext(ctx, setNotificationWebhook, arg.Pid, arg.Url)
+	return err
+}
+
 const setPersonEmail = `-- name: SetPersonEmail :one
-UPDATE Person SET email = ? WHERE id = ? RETURNING id, handle, password, salt, created_at, display_name, email
+UPDATE Person SET email = NULL, email_enc = ?, email_idx = ? WHERE id = ? RETURNING id, handle, password, salt, created_at, display_name, email, email_enc, email_idx, deleted_at, language
 `
 
 type SetPersonEmailParams struct {
-	Email sql.NullString
-	ID    string
+	EmailEnc []byte
+	EmailIdx []byte
+	ID       string
 }
 
 func (q *Queries) SetPersonEmail(ctx context.Context, arg SetPersonEmailParams) (Person, error) {
-	row := q.db.QueryRowContext(ctx, setPersonEmail, arg.Email, arg.ID)
+	row := q.db.QueryRowContext(ctx, setPersonEmail, arg.EmailEnc, arg.EmailIdx, arg.ID)
 	var i Person
 	err := row.Scan(
 		&i.ID,
@@ -184,14 +1061,32 @@ func (q *Queries) SetPersonEmail(ctx context.Context, arg SetPersonEmailParams)
 		&i.CreatedAt,
 		&i.DisplayName,
 		&i.Email,
+		&i.EmailEnc,
+		&i.EmailIdx,
+		&i.DeletedAt,
+		&i.Language,
 	)
 	return i, err
 }
 
+const setPersonLanguage = `-- name: SetPersonLanguage :exec
+UPDATE Person SET language = ? WHERE id = ?
+`
+
+type SetPersonLanguageParams struct {
+	Language string
+	ID       string
+}
+
+func (q *Queries) SetPersonLanguage(ctx context.Context, arg SetPersonLanguageParams) error {
+	_, err := q.db.ExecContext(ctx, setPersonLanguage, arg.Language, arg.ID)
+	return err
+}
+
 const setRegistrationToken = `-- name: SetRegistrationToken :exec
 INSERT INTO RegistrationToken (pid, email, token, expires, refresh)
        VALUES (?, ?, ?, ?, ?)
-ON CONFLICT DO UPDATE SET
+ON CONFLICT(pid) DO UPDATE SET
     email = excluded.email,
     token = excluded.token,
     expires = excluded.expires,
@@ -216,3 +1111,20 @@ func (q *Queries) SetRegistrationToken(ctx context.Context, arg SetRegistrationT
 	)
 	return err
 }
+
+const softDeletePerson = `-- name: SoftDeletePerson :execrows
+UPDATE Person SET deleted_at = ? WHERE id = ? AND deleted_at IS NULL
+`
+
+type SoftDeletePersonParams struct {
+	DeletedAt sql.NullString
+	ID        string
+}
+
+func (q *Queries) SoftDeletePerson(ctx context.Context, arg SoftDeletePersonParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, softDeletePerson, arg.DeletedAt, arg.ID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/mattn/go-sqlite3"
+)
+
+var (
+	// ErrBusy means a transaction failed because of concurrent ones: SQLite was busy or locked, or
+	// Postgres aborted it with a serialization failure or a deadlock. It is safe to retry.
+	ErrBusy = errors.New("database is busy")
+
+	// ErrConstraint means a transaction violated a constraint, like a duplicate unique key.
+	ErrConstraint = errors.New("constraint violation")
+
+	// ErrReadOnly means something tried to write in a Read transaction.
+	ErrReadOnly = errors.New("write in a read-only transaction")
+)
+
+const (
+	maxAttempts  = 5
+	retryBackoff = 10 * time.Millisecond
+	maxBackoff   = 500 * time.Millisecond
+)
+
+// classify wraps err with the error class it belongs to, if any, keeping the driver error
+// reachable with errors.As.
+func classify(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var class error
+	var sqliteErr sqlite3.Error
+	var pgErr *pgconn.PgError
+	switch {
+	case errors.As(err, &sqliteErr):
+		switch sqliteErr.Code {
+		case sqlite3.ErrBusy, sqlite3.ErrLocked:
+			class = ErrBusy
+		case sqlite3.ErrConstraint:
+			class = ErrConstraint
+		case sqlite3.ErrReadonly:
+			class = ErrReadOnly
+		}
+	case errors.As(err, &pgErr):
+		switch {
+		case pgErr.Code == "40001" || pgErr.Code == "40P01":
+			class = ErrBusy
+		case len(pgErr.Code) == 5 && pgErr.Code[:2] == "23":
+			class = ErrConstraint
+		case pgErr.Code == "25006":
+			class = ErrReadOnly
+		}
+	}
+
+	if class == nil || errors.Is(err, class) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", class, err)
+}
+
+// Retry runs f, usually a call to Write, until it succeeds, fails with an error other than ErrBusy
+// or runs out of attempts, waiting with exponential backoff and full jitter between attempts.
+// Writes are only retried through Retry, so f must be safe to run more than once: it may not have
+// side effects outside the database, like sending an email or updating a cache. When ctx comes
+// from WriteTx, f runs once, because only the whole transaction could be retried.
+func (db *DB[Queries]) Retry(ctx context.Context, f func() error) error {
+	if db.activeTx(ctx) != nil {
+		return classify(f())
+	}
+	return db.retry(ctx, "write", f)
+}
+
+func (db *DB[Queries]) retry(ctx context.Context, op string, f func() error) error {
+	backoff := retryBackoff
+	for attempt := 1; ; attempt++ {
+		err := classify(f())
+		if err == nil || attempt == maxAttempts || !errors.Is(err, ErrBusy) {
+			return err
+		}
+		db.metrics.retry(ctx, db.dialect, op)
+
+		select {
+		case <-ctx.Done():
+			return errors.Join(err, ctx.Err())
+		case <-time.After(rand.N(backoff) + time.Millisecond):
+		}
+		backoff = min(2*backoff, maxBackoff)
+	}
+}
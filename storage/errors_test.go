@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/mattn/go-sqlite3"
+)
+
+func TestClassify(t *testing.T) {
+	other := errors.New("other")
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"sqlite busy", sqlite3.Error{Code: sqlite3.ErrBusy}, ErrBusy},
+		{"sqlite locked", sqlite3.Error{Code: sqlite3.ErrLocked}, ErrBusy},
+		{"sqlite unique", sqlite3.Error{Code: sqlite3.ErrConstraint, ExtendedCode: sqlite3.ErrConstraintUnique}, ErrConstraint},
+		{"sqlite foreign key", sqlite3.Error{Code: sqlite3.ErrConstraint, ExtendedCode: sqlite3.ErrConstraintForeignKey}, ErrConstraint},
+		{"sqlite read only", sqlite3.Error{Code: sqlite3.ErrReadonly}, ErrReadOnly},
+		{"sqlite other", sqlite3.Error{Code: sqlite3.ErrCorrupt}, nil},
+		{"postgres serialization failure", &pgconn.PgError{Code: "40001"}, ErrBusy},
+		{"postgres deadlock", &pgconn.PgError{Code: "40P01"}, ErrBusy},
+		{"postgres unique", &pgconn.PgError{Code: "23505"}, ErrConstraint},
+		{"postgres foreign key", &pgconn.PgError{Code: "23503"}, ErrConstraint},
+		{"postgres not null", &pgconn.PgError{Code: "23502"}, ErrConstraint},
+		{"postgres read only", &pgconn.PgError{Code: "25006"}, ErrReadOnly},
+		{"postgres syntax", &pgconn.PgError{Code: "42601"}, nil},
+		{"wrapped", fmt.Errorf("creating user: %w", sqlite3.Error{Code: sqlite3.ErrBusy}), ErrBusy},
+		{"other", other, nil},
+	}
+	for _, tt := range tests {
+		got := classify(tt.err)
+		if !errors.Is(got, tt.err) {
+			t.Errorf("%s: classify = %v, which does not wrap %v", tt.name, got, tt.err)
+		}
+		for _, class := range []error{ErrBusy, ErrConstraint, ErrReadOnly} {
+			if errors.Is(got, class) != (class == tt.want) {
+				t.Errorf("%s: classify = %v, want class %v", tt.name, got, tt.want)
+			}
+		}
+	}
+	if err := classify(nil); err != nil {
+		t.Errorf("classify(nil) = %v", err)
+	}
+
+	// Classifying twice does not wrap twice.
+	once := classify(sqlite3.Error{Code: sqlite3.ErrBusy})
+	if twice := classify(once); twice != once {
+		t.Errorf("classify(classify(err)) = %v, want %v", twice, once)
+	}
+}
+
+func testRetryDB(t *testing.T) *DB[struct{}] {
+	t.Helper()
+	m, err := newMetrics(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &DB[struct{}]{dialect: SQLite, metrics: m}
+}
+
+func TestRetry(t *testing.T) {
+	db := testRetryDB(t)
+	ctx := context.Background()
+	busy := sqlite3.Error{Code: sqlite3.ErrBusy}
+	constraint := sqlite3.Error{Code: sqlite3.ErrConstraint}
+	other := errors.New("other")
+
+	tests := []struct {
+		name     string
+		fails    int
+		err      error
+		attempts int
+		want     error
+	}{
+		{"success", 0, nil, 1, nil},
+		{"busy then success", 2, busy, 3, nil},
+		{"always busy", 100, busy, maxAttempts, ErrBusy},
+		{"constraint", 100, constraint, 1, ErrConstraint},
+		{"other", 100, other, 1, other},
+	}
+	for _, tt := range tests {
+		attempts := 0
+		err := db.Retry(ctx, func() error {
+			attempts++
+			if attempts <= tt.fails {
+				return tt.err
+			}
+			return nil
+		})
+		if attempts != tt.attempts {
+			t.Errorf("%s: %d attempts, want %d", tt.name, attempts, tt.attempts)
+		}
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: Retry = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestRetryStopsWhenCancelled(t *testing.T) {
+	db := testRetryDB(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	attempts := 0
+	err := db.Retry(ctx, func() error {
+		attempts++
+		return sqlite3.Error{Code: sqlite3.ErrBusy}
+	})
+	if attempts != 1 {
+		t.Errorf("%d attempts, want 1", attempts)
+	}
+	if !errors.Is(err, ErrBusy) || !errors.Is(err, context.Canceled) {
+		t.Errorf("Retry = %v, want ErrBusy and context.Canceled", err)
+	}
+}
@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pressly/goose/v3"
+)
+
+// gooseDialect is the dialect goose was last set up for. Go migrations need it to build their DB,
+// and goose's configuration is global anyway.
+var gooseDialect = SQLite
+
+// Migration is what a Go migration gets to work with: the database being migrated, accessed
+// through Queries like everywhere else.
+type Migration[Queries any] struct {
+	*DB[Queries]
+	Version int64
+	Name    string
+}
+
+// RegisterMigration adds a Go migration to the same versioning as the SQL migrations, for changes
+// that need Go logic like rehashing passwords or backfilling columns. version must not be used by
+// any SQL migration. It must be called before the database is opened, usually from an init
+// function in the package that defines Queries.
+//
+// The migration does not run in a single transaction: up and down start their own with Read,
+// Write or Batches, which lets large tables be migrated in resumable batches. down may be nil if
+// the migration cannot be rolled back.
+func RegisterMigration[Queries any](
+	version int64,
+	name string,
+	factory func(tx DBTX) *Queries,
+	up, down func(ctx context.Context, m *Migration[Queries]) error,
+) {
+	run := func(f func(context.Context, *Migration[Queries]) error) goose.GoMigrationNoTxContext {
+		if f == nil {
+			return nil
+		}
+		return func(ctx context.Context, db *sql.DB) error {
+			metrics, err := newMetrics(nil)
+			if err != nil {
+				return err
+			}
+			m := &Migration[Queries]{
+				DB: &DB[Queries]{
+					factory: factory,
+					dialect: gooseDialect,
+					rddb:    db,
+					wrdb:    db,
+					metrics: metrics,
+					tracer:  newTracer(gooseDialect, metrics, options{}),
+				},
+				Version: version,
+				Name:    name,
+			}
+			if gooseDialect == SQLite {
+				m.mu = &sync.Mutex{}
+			}
+			return f(ctx, m)
+		}
+	}
+	goose.AddNamedMigrationNoTxContext(fmt.Sprintf("%05d_%s.go", version, name), run(up), run(down))
+}
+
+// BatchFunc migrates the batch of rows after cursor, which is empty for the first batch. It returns
+// the cursor of the last row it migrated and how many rows that was; no rows means it is done.
+type BatchFunc[Queries any] func(
+	ctx context.Context, queries *Queries, cursor string) (next string, rows int, err error)
+
+// Batches calls f until it runs out of rows, each batch in its own write transaction. The cursor
+// is saved with each batch, so if the migration is interrupted it resumes after the last batch
+// that committed. Progress is logged every few seconds.
+func (m *Migration[Queries]) Batches(ctx context.Context, f BatchFunc[Queries]) error {
+	var cursor string
+	var processed int64
+	err := m.transaction(ctx, m.rddb, readOnly, func(ctx context.Context, tx DBTX) error {
+		err := tx.QueryRowContext(ctx,
+			"SELECT next_cursor, processed FROM MigrationProgress WHERE version = ?",
+			m.Version).Scan(&cursor, &processed)
+		if NoRows(err) {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if processed > 0 {
+		log.Printf("migration %d_%s: resuming after %d rows", m.Version, m.Name, processed)
+	}
+
+	start := time.Now()
+	lastReport := start
+	for {
+		var next string
+		var rows int
+		err := m.write(ctx, func(ctx context.Context, tx DBTX) error {
+			var err error
+			if next, rows, err = f(ctx, m.factory(tx), cursor); err != nil || rows == 0 {
+				return err
+			}
+
+			_, err = tx.ExecContext(ctx, `
+				INSERT INTO MigrationProgress(version, next_cursor, processed, updated_at)
+				VALUES(?, ?, ?, ?)
+				ON CONFLICT(version) DO UPDATE SET
+					next_cursor = excluded.next_cursor,
+					processed = excluded.processed,
+					updated_at = excluded.updated_at`,
+				m.Version, next, processed+int64(rows), time.Now().UTC().Format(time.RFC3339))
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("migration %d_%s failed after %d rows: %w", m.Version, m.Name, processed, err)
+		}
+		if rows == 0 {
+			break
+		}
+
+		cursor = next
+		processed += int64(rows)
+		if time.Since(lastReport) >= 5*time.Second {
+			lastReport = time.Now()
+			log.Printf("migration %d_%s: %d rows migrated", m.Version, m.Name, processed)
+		}
+	}
+
+	// Done: forget the progress so the migration starts over if it is redone.
+	err = m.write(ctx, func(ctx context.Context, tx DBTX) error {
+		_, err := tx.ExecContext(ctx, "DELETE FROM MigrationProgress WHERE version = ?", m.Version)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("migration %d_%s: %d rows migrated in %s",
+		m.Version, m.Name, processed, time.Since(start).Round(time.Millisecond))
+	return nil
+}
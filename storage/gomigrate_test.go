@@ -0,0 +1,206 @@
+package storage_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/avalonbits/echo-template-service/storage"
+	"github.com/avalonbits/echo-template-service/storage/datastore"
+)
+
+// backfillVersion is a Go migration that sets the display name of people without one to their
+// handle, two at a time. It runs for every database the tests migrate, where it usually finds
+// nobody to backfill.
+const backfillVersion = 99999
+
+var (
+	// backfillFailAfter makes the batch after this cursor fail, when set.
+	backfillFailAfter string
+	// backfillCursors are the cursors the backfill batches got.
+	backfillCursors []string
+)
+
+func init() {
+	storage.RegisterMigration(backfillVersion, "backfill_display_name", newRawQueries,
+		func(ctx context.Context, m *storage.Migration[rawQueries]) error {
+			return m.Batches(ctx, backfillBatch)
+		},
+		func(ctx context.Context, m *storage.Migration[rawQueries]) error {
+			return m.WriteRaw(ctx, func(ctx context.Context, tx storage.DBTX) error {
+				_, err := tx.ExecContext(ctx, "UPDATE Person SET display_name = NULL WHERE display_name = handle")
+				return err
+			})
+		})
+}
+
+type rawQueries struct {
+	tx storage.DBTX
+}
+
+func newRawQueries(tx storage.DBTX) *rawQueries {
+	return &rawQueries{tx: tx}
+}
+
+func backfillBatch(ctx context.Context, queries *rawQueries, cursor string) (string, int, error) {
+	backfillCursors = append(backfillCursors, cursor)
+	if backfillFailAfter != "" && cursor == backfillFailAfter {
+		return "", 0, errors.New("interrupted")
+	}
+
+	rows, err := queries.tx.QueryContext(ctx,
+		"SELECT id FROM Person WHERE id > ? AND display_name IS NULL ORDER BY id LIMIT 2", cursor)
+	if err != nil {
+		return "", 0, err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return "", 0, err
+		}
+		ids = append(ids, id)
+	}
+	if err := errors.Join(rows.Err(), rows.Close()); err != nil || len(ids) == 0 {
+		return "", 0, err
+	}
+
+	for _, id := range ids {
+		if _, err := queries.tx.ExecContext(ctx,
+			"UPDATE Person SET display_name = handle WHERE id = ?", id); err != nil {
+			return "", 0, err
+		}
+	}
+	return ids[len(ids)-1], len(ids), nil
+}
+
+func TestGoMigrationResumesBatches(t *testing.T) {
+	t.Cleanup(func() { backfillFailAfter, backfillCursors = "", nil })
+	ctx := context.Background()
+	dbName := filepath.Join(t.TempDir(), "test.db")
+
+	migrator, err := storage.NewMigrator(dbName, datastore.Migrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer migrator.Close()
+	pending, err := migrator.Pending()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) < 2 || pending[len(pending)-1] != backfillVersion {
+		t.Fatalf("pending migrations = %v, want the backfill last", pending)
+	}
+	if err := migrator.UpTo(ctx, pending[len(pending)-2]); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := storage.GetDB(dbName, datastore.Migrations, datastore.Factory, storage.SkipMigrations())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	people := []string{"p1", "p2", "p3", "p4", "p5"}
+	err = db.Write(ctx, func(queries *datastore.Queries) error {
+		for _, id := range people {
+			if err := createUser(ctx, queries, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The second batch fails: the first one stays committed, with its cursor.
+	backfillFailAfter = "p2"
+	if err := migrator.UpTo(ctx, backfillVersion); err == nil {
+		t.Fatal("the interrupted migration succeeded")
+	}
+	if got := backfilled(t, db); !slices.Equal(got, people[:2]) {
+		t.Errorf("backfilled %v after the interruption, want %v", got, people[:2])
+	}
+	cursor, processed := progress(t, db)
+	if cursor != "p2" || processed != 2 {
+		t.Errorf("progress = %q, %d; want p2, 2", cursor, processed)
+	}
+	if pending, err := migrator.Pending(); err != nil || !slices.Equal(pending, []int64{backfillVersion}) {
+		t.Errorf("pending migrations = %v, %v; want the backfill", pending, err)
+	}
+
+	// Running it again resumes after the cursor and forgets the progress when done.
+	backfillFailAfter, backfillCursors = "", nil
+	if err := migrator.UpTo(ctx, backfillVersion); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"p2", "p4", "p5"}; !slices.Equal(backfillCursors, want) {
+		t.Errorf("resumed with cursors %v, want %v", backfillCursors, want)
+	}
+	if got := backfilled(t, db); !slices.Equal(got, people) {
+		t.Errorf("backfilled %v, want %v", got, people)
+	}
+	if cursor, processed := progress(t, db); cursor != "" || processed != 0 {
+		t.Errorf("progress = %q, %d after the migration; want none", cursor, processed)
+	}
+	if pending, err := migrator.Pending(); err != nil || len(pending) != 0 {
+		t.Errorf("pending migrations = %v, %v; want none", pending, err)
+	}
+
+	// Down rolls it back, and up starts over from the beginning.
+	if err := migrator.Down(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := backfilled(t, db); len(got) != 0 {
+		t.Errorf("backfilled %v after down, want none", got)
+	}
+	backfillCursors = nil
+	if err := migrator.UpTo(ctx, backfillVersion); err != nil {
+		t.Fatal(err)
+	}
+	if backfillCursors[0] != "" {
+		t.Errorf("the backfill started after %q after down, want from the beginning", backfillCursors[0])
+	}
+	if got := backfilled(t, db); !slices.Equal(got, people) {
+		t.Errorf("backfilled %v after up, want %v", got, people)
+	}
+}
+
+// backfilled returns the people whose display name is their handle.
+func backfilled(t *testing.T, db *storage.DB[datastore.Queries]) []string {
+	t.Helper()
+	rows, err := db.RDBMS().Query("SELECT id FROM Person WHERE display_name = handle ORDER BY id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return ids
+}
+
+// progress returns the saved progress of the backfill, if any.
+func progress(t *testing.T, db *storage.DB[datastore.Queries]) (string, int64) {
+	t.Helper()
+	var cursor string
+	var processed int64
+	err := db.RDBMS().QueryRow("SELECT next_cursor, processed FROM MigrationProgress WHERE version = ?",
+		backfillVersion).Scan(&cursor, &processed)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		t.Fatal(err)
+	}
+	return cursor, processed
+}
@@ -0,0 +1,92 @@
+package jobs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	// A Wednesday.
+	after := time.Date(2024, time.January, 10, 10, 30, 15, 0, time.UTC)
+	tests := []struct {
+		spec string
+		next time.Time
+	}{
+		{"* * * * *", time.Date(2024, time.January, 10, 10, 31, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2024, time.January, 10, 11, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2024, time.January, 11, 0, 0, 0, 0, time.UTC)},
+		{"@midnight", time.Date(2024, time.January, 11, 0, 0, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2024, time.January, 14, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC)},
+		{"@every 90s", time.Date(2024, time.January, 10, 10, 31, 45, 0, time.UTC)},
+		{"30 10 * * *", time.Date(2024, time.January, 11, 10, 30, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, time.January, 10, 10, 45, 0, 0, time.UTC)},
+		{"5-10/5 * * * *", time.Date(2024, time.January, 10, 11, 5, 0, 0, time.UTC)},
+		{"0 9,18 * * *", time.Date(2024, time.January, 10, 18, 0, 0, 0, time.UTC)},
+		{"0 0 * * 1-5", time.Date(2024, time.January, 11, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2024, time.January, 14, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 5-7", time.Date(2024, time.January, 12, 0, 0, 0, 0, time.UTC)},
+		{"0 0 31 * *", time.Date(2024, time.January, 31, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 12 1 */3 *", time.Date(2024, time.April, 1, 12, 0, 0, 0, time.UTC)},
+		// Day of month and day of week both restricted: either one matching is enough.
+		{"0 0 15 * 5", time.Date(2024, time.January, 12, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		s, err := ParseSchedule(tt.spec)
+		if err != nil {
+			t.Errorf("ParseSchedule(%q) = %v", tt.spec, err)
+			continue
+		}
+		if next := s.Next(after); !next.Equal(tt.next) {
+			t.Errorf("%q: Next(%s) = %s, want %s", tt.spec, after, next, tt.next)
+		}
+	}
+}
+
+func TestParseScheduleLeapDay(t *testing.T) {
+	s, err := ParseSchedule("0 0 29 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	after := time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)
+	want := time.Date(2028, time.February, 29, 0, 0, 0, 0, time.UTC)
+	if next := s.Next(after); !next.Equal(want) {
+		t.Errorf("Next(%s) = %s, want %s", after, next, want)
+	}
+}
+
+func TestParseScheduleNever(t *testing.T) {
+	s, err := ParseSchedule("0 0 31 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next := s.Next(time.Now()); !next.IsZero() {
+		t.Errorf("Next = %s, want zero for a schedule that never runs", next)
+	}
+}
+
+func TestParseScheduleInvalid(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+		"1-b * * * *",
+		"@every",
+		"@every 10ms",
+		"@every soon",
+		"@yearly",
+	} {
+		if _, err := ParseSchedule(spec); err == nil {
+			t.Errorf("ParseSchedule(%q) = nil, want an error", spec)
+		}
+	}
+}
@@ -0,0 +1,536 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	mrand "math/rand/v2"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/avalonbits/echo-template-service/storage"
+	"github.com/avalonbits/echo-template-service/storage/datastore"
+	"github.com/oklog/ulid"
+)
+
+type Job struct {
+	ID      string
+	Kind    string
+	Payload []byte
+	Attempt int
+}
+
+func (j Job) Decode(v any) error {
+	return json.Unmarshal(j.Payload, v)
+}
+
+// Handler runs a job. Returning an error schedules a retry with backoff until the job runs out of
+// attempts, at which point it is dead-lettered. Handlers may run more than once for the same job
+// (e.g. if the process crashes mid-run), so they must be idempotent.
+type Handler func(ctx context.Context, job Job) error
+
+type permanentError struct {
+	err error
+}
+
+func (pe permanentError) Error() string {
+	return pe.err.Error()
+}
+
+func (pe permanentError) Unwrap() error {
+	return pe.err
+}
+
+// Permanent marks err as not worth retrying: the job is dead-lettered right away.
+func Permanent(err error) error {
+	return permanentError{err: err}
+}
+
+type enqueueOptions struct {
+	runAt       time.Time
+	maxAttempts int
+	uniqueKey   string
+}
+
+type EnqueueOption func(*enqueueOptions)
+
+// RunAt delays the job until t.
+func RunAt(t time.Time) EnqueueOption {
+	return func(o *enqueueOptions) {
+		o.runAt = t.UTC()
+	}
+}
+
+// Delay delays the job by d.
+func Delay(d time.Duration) EnqueueOption {
+	return func(o *enqueueOptions) {
+		o.runAt = time.Now().UTC().Add(d)
+	}
+}
+
+func MaxAttempts(n int) EnqueueOption {
+	return func(o *enqueueOptions) {
+		o.maxAttempts = max(1, n)
+	}
+}
+
+// UniqueKey drops the job if another one with the same key is pending or running.
+func UniqueKey(key string) EnqueueOption {
+	return func(o *enqueueOptions) {
+		o.uniqueKey = key
+	}
+}
+
+const defaultMaxAttempts = 10
+
+// Enqueue adds a job to the queue using the transaction queries belongs to, so the job only
+// becomes visible to workers if the transaction commits. payload is stored as JSON.
+func Enqueue(
+	ctx context.Context,
+	queries *datastore.Queries,
+	kind string,
+	payload any,
+	opts ...EnqueueOption,
+) error {
+	now := time.Now().UTC()
+	o := enqueueOptions{
+		runAt:       now,
+		maxAttempts: defaultMaxAttempts,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("error encoding %s job payload: %w", kind, err)
+	}
+	id, err := ulid.New(uint64(now.UnixMilli()), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("error creating job id: %w", err)
+	}
+
+	return queries.EnqueueJob(ctx, datastore.EnqueueJobParams{
+		ID:          id.String(),
+		Kind:        kind,
+		Payload:     data,
+		MaxAttempts: int64(o.maxAttempts),
+		RunAt:       o.runAt.Format(time.RFC3339),
+		UniqueKey:   sql.NullString{String: o.uniqueKey, Valid: o.uniqueKey != ""},
+		CreatedAt:   now.Format(time.RFC3339),
+	})
+}
+
+type workerOptions struct {
+	concurrency int
+	timeout     time.Duration
+}
+
+type WorkerOption func(*workerOptions)
+
+// Concurrency limits how many jobs of a kind run at the same time. The default is 1.
+func Concurrency(n int) WorkerOption {
+	return func(o *workerOptions) {
+		o.concurrency = max(1, n)
+	}
+}
+
+// Timeout bounds how long a single run may take. It is also how long a job stays claimed, so a
+// job from a crashed process is picked up again after it expires. The default is 5 minutes.
+func Timeout(d time.Duration) WorkerOption {
+	return func(o *workerOptions) {
+		o.timeout = d
+	}
+}
+
+type worker struct {
+	handler Handler
+	workerOptions
+
+	running int
+}
+
+type schedule struct {
+	name     string
+	kind     string
+	payload  any
+	schedule Schedule
+}
+
+const (
+	pollInterval = time.Second
+	staleEvery   = time.Minute
+	baseBackoff  = 30 * time.Second
+	maxBackoff   = time.Hour
+	cronPrefix   = "cron:"
+)
+
+type Runner struct {
+	db *storage.DB[datastore.Queries]
+
+	mu        sync.Mutex
+	workers   map[string]*worker
+	schedules map[string]schedule
+
+	stop    chan struct{}
+	done    chan struct{}
+	running sync.WaitGroup
+	jobCtx  context.Context
+	cancel  context.CancelFunc
+}
+
+func New(db *storage.DB[datastore.Queries]) *Runner {
+	return &Runner{
+		db:        db,
+		workers:   map[string]*worker{},
+		schedules: map[string]schedule{},
+	}
+}
+
+// Register sets the handler for jobs of kind. It must be called before Start.
+func (r *Runner) Register(kind string, h Handler, opts ...WorkerOption) {
+	w := &worker{
+		handler: h,
+		workerOptions: workerOptions{
+			concurrency: 1,
+			timeout:     5 * time.Minute,
+		},
+	}
+	for _, opt := range opts {
+		opt(&w.workerOptions)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.workers[kind]; ok {
+		panic(fmt.Sprintf("job handler for %q already registered", kind))
+	}
+	r.workers[kind] = w
+}
+
+// Schedule enqueues a kind job with payload according to spec (see ParseSchedule). name
+// identifies the schedule across restarts, so there is at most one pending run per name. It
+// must be called before Start.
+func (r *Runner) Schedule(name, spec, kind string, payload any) error {
+	s, err := ParseSchedule(spec)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.schedules[name]; ok {
+		return fmt.Errorf("schedule %q already registered", name)
+	}
+	r.schedules[name] = schedule{name: name, kind: kind, payload: payload, schedule: s}
+	return nil
+}
+
+// Start makes sure every schedule has a pending run and starts processing jobs in the
+// background until Shutdown is called.
+func (r *Runner) Start(ctx context.Context) error {
+	err := r.db.Write(ctx, func(queries *datastore.Queries) error {
+		now := time.Now().UTC()
+		for _, s := range r.schedules {
+			if err := r.enqueueNext(ctx, queries, s, now); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	r.stop = make(chan struct{})
+	r.done = make(chan struct{})
+	r.jobCtx, r.cancel = context.WithCancel(context.Background())
+	go r.loop()
+	return nil
+}
+
+// Shutdown stops claiming new jobs and waits for running ones to finish. If ctx is done first,
+// running jobs are cancelled and put back in the queue without counting the attempt.
+func (r *Runner) Shutdown(ctx context.Context) error {
+	if r.stop == nil {
+		return nil
+	}
+	close(r.stop)
+	<-r.done
+
+	finished := make(chan struct{})
+	go func() {
+		r.running.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		r.cancel()
+		return nil
+	case <-ctx.Done():
+		r.cancel()
+		<-finished
+		return ctx.Err()
+	}
+}
+
+// Revive puts a dead-lettered job back in the queue with a fresh set of attempts.
+func (r *Runner) Revive(ctx context.Context, id string) error {
+	return r.db.Write(ctx, func(queries *datastore.Queries) error {
+		n, err := queries.ReviveJob(ctx, datastore.ReviveJobParams{
+			RunAt: time.Now().UTC().Format(time.RFC3339),
+			ID:    id,
+		})
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return fmt.Errorf("no dead job with id %q", id)
+		}
+		return nil
+	})
+}
+
+// Dead returns the most recently dead-lettered jobs.
+func (r *Runner) Dead(ctx context.Context, limit int) ([]datastore.Job, error) {
+	var list []datastore.Job
+	err := r.db.Read(ctx, func(queries *datastore.Queries) error {
+		var err error
+		list, err = queries.ListDeadJobs(ctx, int64(limit))
+		return err
+	})
+	return list, err
+}
+
+func (r *Runner) loop() {
+	defer close(r.done)
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	var lastStale time.Time
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+		}
+
+		if time.Since(lastStale) > staleEvery {
+			lastStale = time.Now()
+			if err := r.requeueStale(); err != nil {
+				log.Printf("error requeueing stale jobs: %v", err)
+			}
+		}
+		if err := r.claim(); err != nil {
+			log.Printf("error claiming jobs: %v", err)
+		}
+	}
+}
+
+func (r *Runner) requeueStale() error {
+	ctx := r.jobCtx
+	var n int64
+	err := r.db.Retry(ctx, func() error {
+		return r.db.Write(ctx, func(queries *datastore.Queries) error {
+			var err error
+			n, err = queries.RequeueStaleJobs(ctx, sql.NullString{
+				String: time.Now().UTC().Format(time.RFC3339),
+				Valid:  true,
+			})
+			return err
+		})
+	})
+	if n > 0 {
+		log.Printf("requeued %d stale jobs", n)
+	}
+	return err
+}
+
+// claim starts the jobs that are due, up to the free concurrency of their kind. Most polls find
+// nothing to do, so it checks with a read first and only takes the write lock when there is work.
+func (r *Runner) claim() error {
+	ctx := r.jobCtx
+	now := time.Now().UTC()
+
+	var due []string
+	err := r.db.Read(ctx, func(queries *datastore.Queries) error {
+		var err error
+		due, err = queries.DueJobKinds(ctx, now.Format(time.RFC3339))
+		return err
+	})
+	if err != nil || len(due) == 0 {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	claimed := map[string][]datastore.Job{}
+	err = r.db.Retry(ctx, func() error {
+		return r.db.Write(ctx, func(queries *datastore.Queries) error {
+			clear(claimed)
+			for _, kind := range due {
+				w, ok := r.workers[kind]
+				if !ok {
+					continue
+				}
+				free := w.concurrency - w.running
+				if free <= 0 {
+					continue
+				}
+
+				jobs, err := queries.ClaimJobs(ctx, datastore.ClaimJobsParams{
+					LockedUntil: sql.NullString{
+						String: now.Add(w.timeout).Format(time.RFC3339),
+						Valid:  true,
+					},
+					Kind:  kind,
+					RunAt: now.Format(time.RFC3339),
+					Limit: int64(free),
+				})
+				if err != nil {
+					return err
+				}
+				claimed[kind] = jobs
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	for kind, jobs := range claimed {
+		w := r.workers[kind]
+		for _, job := range jobs {
+			w.running++
+			r.running.Add(1)
+			go r.run(w, job)
+		}
+	}
+	return nil
+}
+
+func (r *Runner) run(w *worker, job datastore.Job) {
+	defer func() {
+		r.mu.Lock()
+		w.running--
+		r.mu.Unlock()
+		r.running.Done()
+	}()
+
+	ctx, cancel := context.WithTimeout(r.jobCtx, w.timeout)
+	err := safeRun(ctx, w.handler, Job{
+		ID:      job.ID,
+		Kind:    job.Kind,
+		Payload: job.Payload,
+		Attempt: int(job.Attempts),
+	})
+	cancel()
+
+	// Use a fresh context: the job context may have been cancelled by Shutdown, and we still want
+	// to record what happened.
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := r.finish(ctx, job, err); err != nil {
+		log.Printf("error finishing %s job %s: %v", job.Kind, job.ID, err)
+	}
+}
+
+func safeRun(ctx context.Context, h Handler, job Job) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("job panicked: %v", p)
+		}
+	}()
+	return h(ctx, job)
+}
+
+func (r *Runner) finish(ctx context.Context, job datastore.Job, runErr error) error {
+	now := time.Now().UTC()
+	nowStr := sql.NullString{String: now.Format(time.RFC3339), Valid: true}
+
+	buried := false
+	err := r.db.Retry(ctx, func() error {
+		buried = false
+		return r.db.Write(ctx, func(queries *datastore.Queries) error {
+			switch {
+			case runErr == nil:
+				if err := queries.CompleteJob(ctx, datastore.CompleteJobParams{
+					FinishedAt: nowStr,
+					ID:         job.ID,
+				}); err != nil {
+					return err
+				}
+
+			case r.jobCtx.Err() != nil:
+				// We are shutting down: this attempt does not count.
+				return queries.ReleaseJob(ctx, job.ID)
+
+			case job.Attempts < job.MaxAttempts && !isPermanent(runErr):
+				return queries.RetryJob(ctx, datastore.RetryJobParams{
+					LastError: sql.NullString{String: runErr.Error(), Valid: true},
+					RunAt:     now.Add(backoff(int(job.Attempts))).Format(time.RFC3339),
+					ID:        job.ID,
+				})
+
+			default:
+				buried = true
+				if err := queries.BuryJob(ctx, datastore.BuryJobParams{
+					LastError:  sql.NullString{String: runErr.Error(), Valid: true},
+					FinishedAt: nowStr,
+					ID:         job.ID,
+				}); err != nil {
+					return err
+				}
+			}
+
+			// The job is done for good. If it came from a schedule, queue its next run.
+			name, ok := strings.CutPrefix(job.UniqueKey.String, cronPrefix)
+			if !ok {
+				return nil
+			}
+			s, ok := r.schedules[name]
+			if !ok {
+				return nil
+			}
+			return r.enqueueNext(ctx, queries, s, now)
+		})
+	})
+	if err == nil && buried {
+		log.Printf("%s job %s is dead after %d attempts: %v", job.Kind, job.ID, job.Attempts, runErr)
+	}
+	return err
+}
+
+func (r *Runner) enqueueNext(
+	ctx context.Context, queries *datastore.Queries, s schedule, now time.Time) error {
+	next := s.schedule.Next(now)
+	if next.IsZero() {
+		return fmt.Errorf("schedule %q never runs", s.name)
+	}
+	return Enqueue(ctx, queries, s.kind, s.payload, RunAt(next), UniqueKey(cronPrefix+s.name))
+}
+
+func isPermanent(err error) bool {
+	var pe permanentError
+	return errors.As(err, &pe)
+}
+
+// backoff doubles the wait on each attempt, up to maxBackoff, with +/-20% jitter so failing jobs
+// don't retry in lockstep.
+func backoff(attempt int) time.Duration {
+	d := maxBackoff
+	if attempt < 20 {
+		d = min(maxBackoff, baseBackoff<<max(0, attempt-1))
+	}
+	jitter := time.Duration(mrand.Int64N(int64(d)/5*2+1)) - d/5
+	return d + jitter
+}
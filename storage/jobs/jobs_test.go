@@ -0,0 +1,359 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/avalonbits/echo-template-service/storage"
+	"github.com/avalonbits/echo-template-service/storage/datastore"
+)
+
+type jobRow struct {
+	status    string
+	attempts  int64
+	runAt     time.Time
+	lastError string
+}
+
+func testRunner(t *testing.T) (*Runner, *storage.DB[datastore.Queries]) {
+	t.Helper()
+	db := storage.TestDB(datastore.Migrations, datastore.Factory)
+	r := New(db)
+	r.jobCtx, r.cancel = context.WithCancel(context.Background())
+	t.Cleanup(func() {
+		r.cancel()
+		r.running.Wait()
+		if err := db.Close(); err != nil {
+			t.Error(err)
+		}
+	})
+	return r, db
+}
+
+// poll claims due jobs like one tick of the runner loop and waits for them to finish.
+func poll(t *testing.T, r *Runner) {
+	t.Helper()
+	if err := r.claim(); err != nil {
+		t.Fatal(err)
+	}
+	r.running.Wait()
+}
+
+func enqueue(t *testing.T, db *storage.DB[datastore.Queries], kind string, opts ...EnqueueOption) {
+	t.Helper()
+	ctx := context.Background()
+	err := db.Write(ctx, func(queries *datastore.Queries) error {
+		return Enqueue(ctx, queries, kind, map[string]string{"kind": kind}, opts...)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func jobsOf(t *testing.T, db *storage.DB[datastore.Queries], kind string) []jobRow {
+	t.Helper()
+	rows, err := db.RDBMS().Query(
+		"SELECT status, attempts, run_at, coalesce(last_error, '') FROM Job WHERE kind = ? ORDER BY id",
+		kind)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var list []jobRow
+	for rows.Next() {
+		var j jobRow
+		var runAt string
+		if err := rows.Scan(&j.status, &j.attempts, &runAt, &j.lastError); err != nil {
+			t.Fatal(err)
+		}
+		j.runAt, _ = time.Parse(time.RFC3339, runAt)
+		list = append(list, j)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return list
+}
+
+func TestRunCompletesJob(t *testing.T) {
+	r, db := testRunner(t)
+
+	var got Job
+	r.Register("test", func(ctx context.Context, job Job) error {
+		got = job
+		return nil
+	})
+	enqueue(t, db, "test")
+	poll(t, r)
+
+	var payload map[string]string
+	if err := got.Decode(&payload); err != nil || payload["kind"] != "test" {
+		t.Errorf("payload = %v, %v; want the enqueued one", payload, err)
+	}
+	if got.Attempt != 1 {
+		t.Errorf("Attempt = %d, want 1", got.Attempt)
+	}
+	if list := jobsOf(t, db, "test"); len(list) != 1 || list[0].status != "done" {
+		t.Errorf("jobs = %+v, want one done job", list)
+	}
+}
+
+func TestDelayedJobWaits(t *testing.T) {
+	r, db := testRunner(t)
+
+	ran := false
+	r.Register("test", func(ctx context.Context, job Job) error {
+		ran = true
+		return nil
+	})
+	enqueue(t, db, "test", Delay(time.Hour))
+	poll(t, r)
+
+	if ran {
+		t.Error("a job delayed by an hour ran right away")
+	}
+	if list := jobsOf(t, db, "test"); len(list) != 1 || list[0].status != "pending" {
+		t.Errorf("jobs = %+v, want one pending job", list)
+	}
+}
+
+func TestFailedJobIsRetriedWithBackoff(t *testing.T) {
+	r, db := testRunner(t)
+
+	r.Register("test", func(ctx context.Context, job Job) error {
+		return errors.New("try again")
+	})
+	enqueue(t, db, "test")
+	start := time.Now().UTC()
+	poll(t, r)
+
+	list := jobsOf(t, db, "test")
+	if len(list) != 1 {
+		t.Fatalf("jobs = %+v, want one", list)
+	}
+	j := list[0]
+	if j.status != "pending" || j.attempts != 1 || j.lastError != "try again" {
+		t.Errorf("job = %+v, want pending after 1 attempt with its error", j)
+	}
+	// The first retry waits baseBackoff, with jitter.
+	if wait := j.runAt.Sub(start); wait < baseBackoff*4/5-time.Second || wait > baseBackoff*6/5+time.Second {
+		t.Errorf("retry in %s, want about %s", wait, baseBackoff)
+	}
+
+	// It is not due yet.
+	poll(t, r)
+	if list := jobsOf(t, db, "test"); list[0].attempts != 1 {
+		t.Errorf("attempts = %d, the retry ran before its backoff", list[0].attempts)
+	}
+}
+
+func TestJobIsDeadAfterMaxAttempts(t *testing.T) {
+	r, db := testRunner(t)
+	ctx := context.Background()
+
+	r.Register("test", func(ctx context.Context, job Job) error {
+		return errors.New("always fails")
+	})
+	enqueue(t, db, "test", MaxAttempts(2))
+
+	poll(t, r)
+	// Make the retry due.
+	if _, err := db.RDBMS().Exec("UPDATE Job SET run_at = ?", time.Now().UTC().Format(time.RFC3339)); err != nil {
+		t.Fatal(err)
+	}
+	poll(t, r)
+
+	list := jobsOf(t, db, "test")
+	if len(list) != 1 || list[0].status != "dead" || list[0].attempts != 2 {
+		t.Fatalf("jobs = %+v, want one dead job after 2 attempts", list)
+	}
+
+	dead, err := r.Dead(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dead) != 1 || dead[0].LastError.String != "always fails" {
+		t.Fatalf("Dead = %+v, want the job with its last error", dead)
+	}
+
+	if err := r.Revive(ctx, dead[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if list := jobsOf(t, db, "test"); list[0].status != "pending" || list[0].attempts != 0 {
+		t.Errorf("revived job = %+v, want pending with no attempts", list[0])
+	}
+	if err := r.Revive(ctx, dead[0].ID); err == nil {
+		t.Error("reviving a job that is not dead succeeded")
+	}
+}
+
+func TestPermanentErrorIsDeadRightAway(t *testing.T) {
+	r, db := testRunner(t)
+
+	r.Register("test", func(ctx context.Context, job Job) error {
+		return Permanent(errors.New("bad payload"))
+	})
+	enqueue(t, db, "test")
+	poll(t, r)
+
+	if list := jobsOf(t, db, "test"); len(list) != 1 || list[0].status != "dead" || list[0].attempts != 1 {
+		t.Errorf("jobs = %+v, want one dead job after 1 attempt", list)
+	}
+}
+
+func TestPanicIsRetried(t *testing.T) {
+	r, db := testRunner(t)
+
+	r.Register("test", func(ctx context.Context, job Job) error {
+		panic("oops")
+	})
+	enqueue(t, db, "test")
+	poll(t, r)
+
+	if list := jobsOf(t, db, "test"); len(list) != 1 || list[0].status != "pending" ||
+		list[0].lastError != "job panicked: oops" {
+		t.Errorf("jobs = %+v, want one pending job with the panic as error", list)
+	}
+}
+
+func TestConcurrencyPerKind(t *testing.T) {
+	r, db := testRunner(t)
+
+	var running, most atomic.Int32
+	release := make(chan struct{})
+	started := make(chan struct{}, 10)
+	r.Register("slow", func(ctx context.Context, job Job) error {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			m := most.Load()
+			if n <= m || most.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		started <- struct{}{}
+		<-release
+		return nil
+	}, Concurrency(2))
+	for range 5 {
+		enqueue(t, db, "slow")
+	}
+
+	if err := r.claim(); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	<-started
+	// Every slot is taken: polling again claims nothing.
+	if err := r.claim(); err != nil {
+		t.Fatal(err)
+	}
+	claimed := 0
+	for _, j := range jobsOf(t, db, "slow") {
+		if j.status == "running" {
+			claimed++
+		}
+	}
+	if claimed != 2 {
+		t.Errorf("%d jobs claimed, want 2", claimed)
+	}
+
+	close(release)
+	r.running.Wait()
+	for range 2 {
+		poll(t, r)
+	}
+	for _, j := range jobsOf(t, db, "slow") {
+		if j.status != "done" {
+			t.Errorf("job = %+v, want done", j)
+		}
+	}
+	if m := most.Load(); m != 2 {
+		t.Errorf("at most %d jobs ran at the same time, want 2", m)
+	}
+}
+
+func TestUniqueKey(t *testing.T) {
+	_, db := testRunner(t)
+
+	enqueue(t, db, "test", UniqueKey("once"))
+	enqueue(t, db, "test", UniqueKey("once"))
+	enqueue(t, db, "test", UniqueKey("other"))
+
+	if list := jobsOf(t, db, "test"); len(list) != 2 {
+		t.Errorf("%d jobs queued, want 2", len(list))
+	}
+}
+
+func TestUnregisteredKindIsLeftAlone(t *testing.T) {
+	r, db := testRunner(t)
+
+	enqueue(t, db, "unknown")
+	poll(t, r)
+
+	if list := jobsOf(t, db, "unknown"); len(list) != 1 || list[0].status != "pending" {
+		t.Errorf("jobs = %+v, want one pending job", list)
+	}
+}
+
+func TestScheduleQueuesNextRun(t *testing.T) {
+	r, db := testRunner(t)
+	ctx := context.Background()
+
+	var runs sync.WaitGroup
+	runs.Add(1)
+	r.Register("tick", func(ctx context.Context, job Job) error {
+		runs.Done()
+		return nil
+	})
+	if err := r.Schedule("ticker", "@every 1h", "tick", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Schedule("ticker", "@daily", "tick", nil); err == nil {
+		t.Error("registering a schedule twice succeeded")
+	}
+	if err := r.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	r.jobCtx, r.cancel = context.WithCancel(ctx)
+
+	list := jobsOf(t, db, "tick")
+	if len(list) != 1 || list[0].status != "pending" {
+		t.Fatalf("jobs = %+v, want the first run pending", list)
+	}
+	if _, err := db.RDBMS().Exec("UPDATE Job SET run_at = ?", time.Now().UTC().Format(time.RFC3339)); err != nil {
+		t.Fatal(err)
+	}
+	poll(t, r)
+	runs.Wait()
+
+	list = jobsOf(t, db, "tick")
+	if len(list) != 2 || list[0].status != "done" || list[1].status != "pending" {
+		t.Fatalf("jobs = %+v, want the first run done and the next one pending", list)
+	}
+	if wait := time.Until(list[1].runAt); wait < 59*time.Minute || wait > time.Hour {
+		t.Errorf("next run in %s, want in an hour", wait)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for attempt := 1; attempt < 30; attempt++ {
+		want := maxBackoff
+		if attempt < 8 {
+			want = baseBackoff << (attempt - 1)
+		}
+		for range 20 {
+			if d := backoff(attempt); d < want*4/5 || d > want*6/5 {
+				t.Fatalf("backoff(%d) = %s, want %s +/-20%%", attempt, d, want)
+			}
+		}
+	}
+}
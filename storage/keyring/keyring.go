@@ -0,0 +1,257 @@
+package keyring
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var (
+	ErrUnknownKey = errors.New("ciphertext was sealed with a key not in the keyring")
+	ErrMalformed  = errors.New("malformed ciphertext")
+)
+
+const (
+	version  = 1
+	keySize  = 32
+	dekSize  = 32
+	wrapSize = 12 + dekSize + 16 // nonce, data key and GCM tag.
+)
+
+// Keyring seals values with envelope encryption: every value gets its own random data key, which
+// is then encrypted ("wrapped") with the current key of the keyring. The ID of that key is stored
+// with the ciphertext, so values sealed with older keys can still be opened and rewrapped after a
+// rotation.
+//
+// It also computes blind indexes, keyed hashes of values that can be looked up by equality without
+// storing the value itself.
+type Keyring struct {
+	current string
+	keys    map[string]cipher.AEAD
+	index   []byte
+}
+
+// file is the JSON format of a keyring. Keys are base64 encoded 32-byte AES keys.
+//
+//	{"current": "2024-06", "keys": {"2024-01": "...", "2024-06": "..."}, "index": "..."}
+//
+// The index key never changes: rotating it would require recomputing every blind index.
+type file struct {
+	Current string            `json:"current"`
+	Keys    map[string]string `json:"keys"`
+	Index   string            `json:"index"`
+}
+
+// Load parses a keyring from its JSON format.
+func Load(data []byte) (*Keyring, error) {
+	var f file
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("invalid keyring: %w", err)
+	}
+	if _, ok := f.Keys[f.Current]; !ok {
+		return nil, fmt.Errorf("invalid keyring: current key %q is not in keys", f.Current)
+	}
+
+	k := &Keyring{current: f.Current, keys: map[string]cipher.AEAD{}}
+	for id, encoded := range f.Keys {
+		if id == "" || len(id) > 255 {
+			return nil, fmt.Errorf("invalid keyring: key id %q must have 1 to 255 bytes", id)
+		}
+		key, err := decodeKey(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid keyring: key %q: %w", id, err)
+		}
+		if k.keys[id], err = newAEAD(key); err != nil {
+			return nil, err
+		}
+	}
+
+	var err error
+	if k.index, err = decodeKey(f.Index); err != nil {
+		return nil, fmt.Errorf("invalid keyring: index key: %w", err)
+	}
+	return k, nil
+}
+
+// LoadFile reads a keyring from a JSON file, typically a mounted secret.
+func LoadFile(path string) (*Keyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Load(data)
+}
+
+// NewKey returns a random key, base64 encoded for a keyring file.
+func NewKey() (string, error) {
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+func decodeKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != keySize {
+		return nil, fmt.Errorf("key must have %d bytes, got %d", keySize, len(key))
+	}
+	return key, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// AAD is the additional data binding a sealed value to where it is stored, so a ciphertext copied
+// to another row or column fails to open.
+func AAD(table, column, id string) []byte {
+	return []byte(table + "." + column + ":" + id)
+}
+
+// Seal encrypts plaintext with a new data key wrapped by the current key. The result is:
+//
+//	version | len(key id) | key id | nonce, wrapped data key, tag | nonce, ciphertext, tag
+func (k *Keyring) Seal(plaintext, aad []byte) ([]byte, error) {
+	dek := make([]byte, dekSize)
+	if _, err := rand.Read(dek); err != nil {
+		return nil, err
+	}
+	data, err := newAEAD(dek)
+	if err != nil {
+		return nil, err
+	}
+
+	out := []byte{version, byte(len(k.current))}
+	out = append(out, k.current...)
+	if out, err = k.wrap(out, dek); err != nil {
+		return nil, err
+	}
+	return seal(out, data, plaintext, aad)
+}
+
+// Open decrypts a value sealed by Seal with any key in the keyring.
+func (k *Keyring) Open(ciphertext, aad []byte) ([]byte, error) {
+	id, wrapped, sealed, err := split(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	kek, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, id)
+	}
+
+	dek, err := open(kek, wrapped, []byte(id))
+	if err != nil {
+		return nil, err
+	}
+	data, err := newAEAD(dek)
+	if err != nil {
+		return nil, err
+	}
+	return open(data, sealed, aad)
+}
+
+// SealString and OpenString are Seal and Open for strings.
+func (k *Keyring) SealString(plaintext string, aad []byte) ([]byte, error) {
+	return k.Seal([]byte(plaintext), aad)
+}
+
+func (k *Keyring) OpenString(ciphertext, aad []byte) (string, error) {
+	plaintext, err := k.Open(ciphertext, aad)
+	return string(plaintext), err
+}
+
+// Current reports whether ciphertext is wrapped by the current key.
+func (k *Keyring) Current(ciphertext []byte) bool {
+	id, _, _, err := split(ciphertext)
+	return err == nil && id == k.current
+}
+
+// Rewrap wraps the data key of ciphertext with the current key. The value itself is not
+// re-encrypted, so rotating keys does not need to know where values belong.
+func (k *Keyring) Rewrap(ciphertext []byte) ([]byte, error) {
+	id, wrapped, sealed, err := split(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	kek, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, id)
+	}
+	dek, err := open(kek, wrapped, []byte(id))
+	if err != nil {
+		return nil, err
+	}
+
+	out := []byte{version, byte(len(k.current))}
+	out = append(out, k.current...)
+	if out, err = k.wrap(out, dek); err != nil {
+		return nil, err
+	}
+	return append(out, sealed...), nil
+}
+
+// BlindIndex returns a keyed hash of value, case and surrounding space insensitive, to look it up
+// without storing it.
+func (k *Keyring) BlindIndex(value string) []byte {
+	mac := hmac.New(sha256.New, k.index)
+	mac.Write([]byte(strings.ToLower(strings.TrimSpace(value))))
+	return mac.Sum(nil)
+}
+
+// Derive returns a secret for purpose, derived from the index key so it never changes either. It
+// is meant for signing data the server hands out, like pagination cursors.
+func (k *Keyring) Derive(purpose string) []byte {
+	mac := hmac.New(sha256.New, k.index)
+	mac.Write([]byte("derive:" + purpose))
+	return mac.Sum(nil)
+}
+
+func (k *Keyring) wrap(out, dek []byte) ([]byte, error) {
+	return seal(out, k.keys[k.current], dek, []byte(k.current))
+}
+
+func seal(out []byte, aead cipher.AEAD, plaintext, aad []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out = append(out, nonce...)
+	return aead.Seal(out, nonce, plaintext, aad), nil
+}
+
+func open(aead cipher.AEAD, sealed, aad []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize()+aead.Overhead() {
+		return nil, ErrMalformed
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, aad)
+}
+
+func split(ciphertext []byte) (id string, wrapped, sealed []byte, err error) {
+	if len(ciphertext) < 2 || ciphertext[0] != version {
+		return "", nil, nil, ErrMalformed
+	}
+	n := int(ciphertext[1])
+	rest := ciphertext[2:]
+	if len(rest) < n+wrapSize {
+		return "", nil, nil, ErrMalformed
+	}
+	return string(rest[:n]), rest[n : n+wrapSize], rest[n+wrapSize:], nil
+}
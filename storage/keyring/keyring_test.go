@@ -0,0 +1,185 @@
+package keyring
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func newKey(t *testing.T) string {
+	t.Helper()
+	key, err := NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func load(t *testing.T, current string, keys map[string]string, index string) *Keyring {
+	t.Helper()
+	data := fmt.Sprintf(`{"current": %q, "keys": {`, current)
+	sep := ""
+	for id, key := range keys {
+		data += fmt.Sprintf(`%s%q: %q`, sep, id, key)
+		sep = ", "
+	}
+	data += fmt.Sprintf(`}, "index": %q}`, index)
+
+	k, err := Load([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestSealOpen(t *testing.T) {
+	k := load(t, "k1", map[string]string{"k1": newKey(t)}, newKey(t))
+	aad := AAD("Person", "email", "alice")
+
+	sealed, err := k.SealString("alice@example.com", aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed, []byte("alice@example.com")) {
+		t.Error("the plaintext is in the ciphertext")
+	}
+	if got, err := k.OpenString(sealed, aad); err != nil || got != "alice@example.com" {
+		t.Errorf("Open = %q, %v; want the sealed value", got, err)
+	}
+
+	again, err := k.SealString("alice@example.com", aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(sealed, again) {
+		t.Error("sealing the same value twice gave the same ciphertext")
+	}
+}
+
+func TestOpenWrongAAD(t *testing.T) {
+	k := load(t, "k1", map[string]string{"k1": newKey(t)}, newKey(t))
+	sealed, err := k.SealString("alice@example.com", AAD("Person", "email", "alice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, aad := range [][]byte{
+		AAD("Person", "email", "bob"),
+		AAD("Person", "name", "alice"),
+		nil,
+	} {
+		if _, err := k.Open(sealed, aad); err == nil {
+			t.Errorf("Open with AAD %q succeeded", aad)
+		}
+	}
+}
+
+func TestRewrapAfterRotation(t *testing.T) {
+	k1, k2, index := newKey(t), newKey(t), newKey(t)
+	old := load(t, "k1", map[string]string{"k1": k1}, index)
+	aad := AAD("Person", "email", "alice")
+	sealed, err := old.SealString("alice@example.com", aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated := load(t, "k2", map[string]string{"k1": k1, "k2": k2}, index)
+	if rotated.Current(sealed) {
+		t.Error("Current = true for a value wrapped by the previous key")
+	}
+	if got, err := rotated.OpenString(sealed, aad); err != nil || got != "alice@example.com" {
+		t.Errorf("Open after rotation = %q, %v; want the sealed value", got, err)
+	}
+
+	rewrapped, err := rotated.Rewrap(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rotated.Current(rewrapped) {
+		t.Error("Current = false after Rewrap")
+	}
+	if got, err := rotated.OpenString(rewrapped, aad); err != nil || got != "alice@example.com" {
+		t.Errorf("Open after Rewrap = %q, %v; want the sealed value", got, err)
+	}
+
+	// Once k1 is retired, only rewrapped values open.
+	retired := load(t, "k2", map[string]string{"k2": k2}, index)
+	if _, err := retired.Open(rewrapped, aad); err != nil {
+		t.Errorf("Open of a rewrapped value = %v", err)
+	}
+	if _, err := retired.Open(sealed, aad); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Open with a retired key = %v, want ErrUnknownKey", err)
+	}
+	if _, err := retired.Rewrap(sealed); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Rewrap with a retired key = %v, want ErrUnknownKey", err)
+	}
+}
+
+func TestMalformed(t *testing.T) {
+	k := load(t, "k1", map[string]string{"k1": newKey(t)}, newKey(t))
+	aad := AAD("Person", "email", "alice")
+	sealed, err := k.SealString("alice@example.com", aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header := 2 + len("k1") + wrapSize
+	for name, input := range map[string][]byte{
+		"nil":         nil,
+		"garbage":     []byte("not a ciphertext at all"),
+		"version":     append([]byte{version + 1}, sealed[1:]...),
+		"key id":      sealed[:2+len("k1")],
+		"wrapped key": sealed[:header-1],
+	} {
+		if _, err := k.Open(input, aad); !errors.Is(err, ErrMalformed) {
+			t.Errorf("Open(%s) = %v, want ErrMalformed", name, err)
+		}
+		if _, err := k.Rewrap(input); !errors.Is(err, ErrMalformed) {
+			t.Errorf("Rewrap(%s) = %v, want ErrMalformed", name, err)
+		}
+		if k.Current(input) {
+			t.Errorf("Current(%s) = true", name)
+		}
+	}
+
+	// Rewrap leaves the value alone, but Open needs all of it.
+	for name, input := range map[string][]byte{
+		"no value":    sealed[:header],
+		"short value": sealed[:header+12],
+	} {
+		if _, err := k.Open(input, aad); !errors.Is(err, ErrMalformed) {
+			t.Errorf("Open(%s) = %v, want ErrMalformed", name, err)
+		}
+	}
+
+	// Cutting or flipping bytes of the value itself fails authentication.
+	if _, err := k.Open(sealed[:len(sealed)-1], aad); err == nil {
+		t.Error("Open of a truncated value succeeded")
+	}
+	tampered := bytes.Clone(sealed)
+	tampered[len(tampered)-1] ^= 1
+	if _, err := k.Open(tampered, aad); err == nil {
+		t.Error("Open of a tampered value succeeded")
+	}
+}
+
+func TestBlindIndex(t *testing.T) {
+	k1, k2, index := newKey(t), newKey(t), newKey(t)
+	before := load(t, "k1", map[string]string{"k1": k1}, index)
+	after := load(t, "k2", map[string]string{"k1": k1, "k2": k2}, index)
+
+	idx := before.BlindIndex("alice@example.com")
+	if !bytes.Equal(after.BlindIndex("alice@example.com"), idx) {
+		t.Error("BlindIndex changed with the data key rotation")
+	}
+	if !bytes.Equal(before.BlindIndex("  Alice@Example.COM "), idx) {
+		t.Error("BlindIndex depends on case or surrounding space")
+	}
+	if bytes.Equal(before.BlindIndex("bob@example.com"), idx) {
+		t.Error("BlindIndex is the same for different values")
+	}
+	other := load(t, "k1", map[string]string{"k1": k1}, newKey(t))
+	if bytes.Equal(other.BlindIndex("alice@example.com"), idx) {
+		t.Error("BlindIndex is the same with another index key")
+	}
+}
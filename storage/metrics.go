@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+)
+
+// WithMeter records database metrics with meter. Without it metrics are discarded.
+func WithMeter(meter metric.Meter) Option {
+	return func(o *options) {
+		o.meter = meter
+	}
+}
+
+type metrics struct {
+	retries  metric.Int64Counter
+	duration metric.Float64Histogram
+}
+
+func newMetrics(meter metric.Meter) (metrics, error) {
+	if meter == nil {
+		meter = noop.NewMeterProvider().Meter("storage")
+	}
+
+	retries, err := meter.Int64Counter(
+		"db.transaction.retries",
+		metric.WithDescription("Number of transactions retried because the database was busy."),
+		metric.WithUnit("{retry}"),
+	)
+	if err != nil {
+		return metrics{}, err
+	}
+
+	duration, err := meter.Float64Histogram(
+		"db.query.duration",
+		metric.WithDescription("Duration of database queries. Reading the rows of queries returning many is not included."),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		return metrics{}, err
+	}
+	return metrics{retries: retries, duration: duration}, nil
+}
+
+func (m metrics) retry(ctx context.Context, dialect Dialect, op string) {
+	m.retries.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("db.system", string(dialect)),
+		attribute.String("db.operation", op),
+	))
+}
+
+func (m metrics) query(ctx context.Context, system attribute.KeyValue, name string, d time.Duration) {
+	m.duration.Record(ctx, d.Seconds(), metric.WithAttributes(
+		system,
+		attribute.String("db.operation.name", name),
+	))
+}
@@ -0,0 +1,167 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pressly/goose/v3"
+)
+
+var ErrPendingMigrations = errors.New("database has pending migrations")
+
+// RequireMigrated makes GetDB fail with ErrPendingMigrations when the database is not up to date,
+// instead of applying the migrations. Use it when migrations are applied by `household migrate`.
+func RequireMigrated() Option {
+	return func(o *options) {
+		o.requireMigrated = true
+	}
+}
+
+// SkipMigrations makes GetDB open the database as it is, neither applying nor checking
+// migrations. Use it for tools that must not change the database, like taking a backup.
+func SkipMigrations() Option {
+	return func(o *options) {
+		o.skipMigrations = true
+	}
+}
+
+func migrate(db *sql.DB, dialect Dialect, migrations embed.FS, o options) error {
+	if o.skipMigrations {
+		return nil
+	}
+	if err := setupGoose(dialect, migrations); err != nil {
+		return err
+	}
+	if !o.requireMigrated {
+		return goose.Up(db, dialect.migrationsDir())
+	}
+
+	pending, err := pendingMigrations(db, dialect)
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%w: %v", ErrPendingMigrations, pending)
+	}
+	return nil
+}
+
+func setupGoose(dialect Dialect, migrations embed.FS) error {
+	goose.SetBaseFS(migrations)
+	gooseDialect = dialect
+	return goose.SetDialect(string(dialect))
+}
+
+func pendingMigrations(db *sql.DB, dialect Dialect) ([]int64, error) {
+	current, err := goose.EnsureDBVersion(db)
+	if err != nil {
+		return nil, err
+	}
+	migrations, err := goose.CollectMigrations(dialect.migrationsDir(), current, goose.MaxVersion)
+	if errors.Is(err, goose.ErrNoMigrationFiles) {
+		// Nothing after the current version: the database is up to date.
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	pending := make([]int64, 0, len(migrations))
+	for _, m := range migrations {
+		pending = append(pending, m.Version)
+	}
+	return pending, nil
+}
+
+// Migrator applies and rolls back migrations on a database one step at a time. Unlike GetDB it
+// does not migrate the database when opening it.
+type Migrator struct {
+	db      *sql.DB
+	dialect Dialect
+}
+
+func NewMigrator(dbURL string, migrations embed.FS) (*Migrator, error) {
+	dialect, driver := SQLite, "sqlite3"
+	if isPostgres(dbURL) {
+		dialect, driver = Postgres, "pgx"
+	} else {
+		dbURL = fmt.Sprintf(writeDSN, dbURL)
+	}
+	if err := setupGoose(dialect, migrations); err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open(driver, dbURL)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, dialect: dialect}, nil
+}
+
+func (m *Migrator) Close() error {
+	return m.db.Close()
+}
+
+// Status logs every migration and when it was applied.
+func (m *Migrator) Status(ctx context.Context) error {
+	return goose.StatusContext(ctx, m.db, m.dialect.migrationsDir())
+}
+
+// Pending returns the versions of the migrations not applied yet.
+func (m *Migrator) Pending() ([]int64, error) {
+	return pendingMigrations(m.db, m.dialect)
+}
+
+// UpTo applies the pending migrations up to and including version.
+func (m *Migrator) UpTo(ctx context.Context, version int64) error {
+	return goose.UpToContext(ctx, m.db, m.dialect.migrationsDir(), version)
+}
+
+// Down rolls back the last applied migration.
+func (m *Migrator) Down(ctx context.Context) error {
+	return goose.DownContext(ctx, m.db, m.dialect.migrationsDir())
+}
+
+// Redo rolls back the last applied migration and applies it again.
+func (m *Migrator) Redo(ctx context.Context) error {
+	return goose.RedoContext(ctx, m.db, m.dialect.migrationsDir())
+}
+
+const migrationTemplate = `-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+-- +goose StatementEnd
+`
+
+// CreateMigration adds an empty migration called name to every dialect directory under dir,
+// versioned with the current time so both dialects get the same version. It returns the paths
+// of the new files.
+func CreateMigration(dir, name string, now time.Time) ([]string, error) {
+	filename := fmt.Sprintf("%s_%s.sql", now.UTC().Format("20060102150405"), name)
+
+	var created []string
+	for _, dialect := range []Dialect{SQLite, Postgres} {
+		path := filepath.Join(dir, string(dialect), filename)
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err != nil {
+			return created, err
+		}
+		_, err = f.WriteString(migrationTemplate)
+		if err := errors.Join(err, f.Close()); err != nil {
+			return created, err
+		}
+		created = append(created, path)
+	}
+	return created, nil
+}
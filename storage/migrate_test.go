@@ -0,0 +1,143 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/avalonbits/echo-template-service/storage"
+	"github.com/avalonbits/echo-template-service/storage/datastore"
+)
+
+func TestRequireMigrated(t *testing.T) {
+	dbName := filepath.Join(t.TempDir(), "test.db")
+
+	_, err := storage.GetDB(dbName, datastore.Migrations, datastore.Factory, storage.RequireMigrated())
+	if !errors.Is(err, storage.ErrPendingMigrations) {
+		t.Fatalf("opening an empty database = %v, want ErrPendingMigrations", err)
+	}
+
+	db, err := storage.GetDB(dbName, datastore.Migrations, datastore.Factory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	db, err = storage.GetDB(dbName, datastore.Migrations, datastore.Factory, storage.RequireMigrated())
+	if err != nil {
+		t.Fatalf("opening a migrated database = %v", err)
+	}
+	db.Close()
+}
+
+func TestMigratorDownRedo(t *testing.T) {
+	ctx := context.Background()
+	dbName := filepath.Join(t.TempDir(), "test.db")
+	db, err := storage.GetDB(dbName, datastore.Migrations, datastore.Factory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	migrated := schema(t, db)
+
+	m, err := storage.NewMigrator(dbName, datastore.Migrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	if pending, err := m.Pending(); err != nil || len(pending) != 0 {
+		t.Fatalf("pending migrations = %v, %v; want none", pending, err)
+	}
+
+	if err := m.Redo(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := schema(t, db); !slices.Equal(got, migrated) {
+		t.Errorf("schema after redo = %v, want %v", got, migrated)
+	}
+
+	// Roll back two migrations, so at least one SQL migration is undone, and apply them again.
+	for range 2 {
+		if err := m.Down(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pending, err := m.Pending()
+	if err != nil || len(pending) != 2 {
+		t.Fatalf("pending migrations after down = %v, %v; want 2", pending, err)
+	}
+	if got := schema(t, db); slices.Equal(got, migrated) {
+		t.Error("down did not change the schema")
+	}
+	if err := m.UpTo(ctx, math.MaxInt64); err != nil {
+		t.Fatal(err)
+	}
+	if got := schema(t, db); !slices.Equal(got, migrated) {
+		t.Errorf("schema after down and up = %v, want %v", got, migrated)
+	}
+}
+
+// schema returns the SQL of every table and index in db.
+func schema(t *testing.T, db *storage.DB[datastore.Queries]) []string {
+	t.Helper()
+	rows, err := db.RDBMS().Query(
+		"SELECT sql FROM sqlite_master WHERE sql IS NOT NULL AND name NOT LIKE 'sqlite_%' ORDER BY name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var all []string
+	for rows.Next() {
+		var sql string
+		if err := rows.Scan(&sql); err != nil {
+			t.Fatal(err)
+		}
+		all = append(all, sql)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return all
+}
+
+func TestCreateMigration(t *testing.T) {
+	dir := t.TempDir()
+	for _, dialect := range []string{"sqlite", "postgres"} {
+		if err := os.Mkdir(filepath.Join(dir, dialect), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Date(2024, 3, 4, 5, 6, 7, 0, time.FixedZone("BRT", -3*60*60))
+
+	created, err := storage.CreateMigration(dir, "add_lists", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		filepath.Join(dir, "sqlite", "20240304080607_add_lists.sql"),
+		filepath.Join(dir, "postgres", "20240304080607_add_lists.sql"),
+	}
+	if !slices.Equal(created, want) {
+		t.Fatalf("created %v, want %v", created, want)
+	}
+	for _, path := range created {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(data), "-- +goose Up") || !strings.Contains(string(data), "-- +goose Down") {
+			t.Errorf("%s is not a goose migration:\n%s", path, data)
+		}
+	}
+
+	// Migrations are never overwritten.
+	if _, err := storage.CreateMigration(dir, "add_lists", now); !errors.Is(err, os.ErrExist) {
+		t.Errorf("creating the migration again = %v, want ErrExist", err)
+	}
+}
@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"slices"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+const cursorMACSize = 16
+
+// Cursors encodes the keys of list items into opaque cursors for keyset pagination. Cursors are
+// signed, so a client can only send back cursors the server gave it.
+//
+// A key must be unique and sort like the list: a ULID, or a created_at time followed by the row
+// id to break ties.
+type Cursors struct {
+	secret []byte
+}
+
+func NewCursors(secret []byte) *Cursors {
+	return &Cursors{secret: secret}
+}
+
+// Encode returns the cursor of key.
+func (c *Cursors) Encode(key string) string {
+	buf := append([]byte(key), c.mac(key)...)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// Decode returns the key of cursor, or ErrInvalidCursor if it was not made by Encode.
+func (c *Cursors) Decode(cursor string) (string, error) {
+	buf, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || len(buf) < cursorMACSize {
+		return "", ErrInvalidCursor
+	}
+	key, mac := string(buf[:len(buf)-cursorMACSize]), buf[len(buf)-cursorMACSize:]
+	if !hmac.Equal(mac, c.mac(key)) {
+		return "", ErrInvalidCursor
+	}
+	return key, nil
+}
+
+func (c *Cursors) mac(key string) []byte {
+	m := hmac.New(sha256.New, c.secret)
+	m.Write([]byte(key))
+	return m.Sum(nil)[:cursorMACSize]
+}
+
+// PageRequest is which page of a list to fetch: the items right after the key After, right before
+// the key Before, or the first ones when both are empty.
+type PageRequest struct {
+	After  string
+	Before string
+	Limit  int
+}
+
+// Page is a page of a list, with the cursors of the pages around it. A cursor is empty when
+// there is no page in that direction.
+type Page[T any] struct {
+	Items []T
+	Next  string
+	Prev  string
+}
+
+// PageFunc fetches up to limit items in list order starting right after key, or in reverse order
+// starting right before key when backward is set. An empty key means from the start, or the end.
+type PageFunc[T any] func(key string, backward bool, limit int64) ([]T, error)
+
+// Paginate fetches the page req asks for with fetch and builds the cursors to its neighbours from
+// the keys of its first and last items.
+func Paginate[T any](req PageRequest, cursors *Cursors, key func(T) string, fetch PageFunc[T]) (Page[T], error) {
+	limit := max(1, req.Limit)
+	backward := req.Before != ""
+	from := req.After
+	if backward {
+		from = req.Before
+	}
+
+	// One more item than needed tells whether there is another page after this one.
+	items, err := fetch(from, backward, int64(limit+1))
+	if err != nil {
+		return Page[T]{}, err
+	}
+	more := len(items) > limit
+	if more {
+		items = items[:limit]
+	}
+	if backward {
+		slices.Reverse(items)
+	}
+
+	page := Page[T]{Items: items}
+	if len(items) == 0 {
+		return page, nil
+	}
+	first, last := cursors.Encode(key(items[0])), cursors.Encode(key(items[len(items)-1]))
+	switch {
+	case backward:
+		page.Next = last
+		if more {
+			page.Prev = first
+		}
+	default:
+		if more {
+			page.Next = last
+		}
+		if from != "" {
+			page.Prev = first
+		}
+	}
+	return page, nil
+}
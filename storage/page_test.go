@@ -0,0 +1,157 @@
+package storage_test
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/avalonbits/echo-template-service/storage"
+)
+
+func TestCursors(t *testing.T) {
+	cursors := storage.NewCursors([]byte("secret"))
+	cursor := cursors.Encode("01HZX5K3AB")
+	if key, err := cursors.Decode(cursor); err != nil || key != "01HZX5K3AB" {
+		t.Fatalf("Decode = %q, %v; want the encoded key", key, err)
+	}
+
+	tampered := []byte(cursor)
+	tampered[0] ^= 1
+	for name, c := range map[string]string{
+		"empty":     "",
+		"not b64":   "not a cursor!",
+		"truncated": cursor[:len(cursor)-2],
+		"short":     cursor[:10],
+		"tampered":  string(tampered),
+		"unsigned":  "MDFIWlg1SzNBQg",
+	} {
+		if key, err := cursors.Decode(c); !errors.Is(err, storage.ErrInvalidCursor) {
+			t.Errorf("Decode(%s) = %q, %v; want ErrInvalidCursor", name, key, err)
+		}
+	}
+
+	other := storage.NewCursors([]byte("another secret"))
+	if key, err := other.Decode(cursor); !errors.Is(err, storage.ErrInvalidCursor) {
+		t.Errorf("Decode with another key = %q, %v; want ErrInvalidCursor", key, err)
+	}
+}
+
+// pager pages through the keys "k01" to "kNN" in order.
+type pager struct {
+	t       *testing.T
+	cursors *storage.Cursors
+	keys    []string
+}
+
+func newPager(t *testing.T, n int) pager {
+	p := pager{t: t, cursors: storage.NewCursors([]byte("secret"))}
+	for i := 1; i <= n; i++ {
+		p.keys = append(p.keys, fmt.Sprintf("k%02d", i))
+	}
+	return p
+}
+
+func (p pager) fetch(key string, backward bool, limit int64) ([]string, error) {
+	var items []string
+	if backward {
+		for i := len(p.keys) - 1; i >= 0 && int64(len(items)) < limit; i-- {
+			if key == "" || p.keys[i] < key {
+				items = append(items, p.keys[i])
+			}
+		}
+		return items, nil
+	}
+	for _, k := range p.keys {
+		if int64(len(items)) < limit && (key == "" || k > key) {
+			items = append(items, k)
+		}
+	}
+	return items, nil
+}
+
+// page fetches the page after or before the cursors given, if any.
+func (p pager) page(after, before string) storage.Page[string] {
+	p.t.Helper()
+	req := storage.PageRequest{Limit: 3}
+	var err error
+	if after != "" {
+		if req.After, err = p.cursors.Decode(after); err != nil {
+			p.t.Fatal(err)
+		}
+	}
+	if before != "" {
+		if req.Before, err = p.cursors.Decode(before); err != nil {
+			p.t.Fatal(err)
+		}
+	}
+	page, err := storage.Paginate(req, p.cursors, func(k string) string { return k }, p.fetch)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	return page
+}
+
+func TestPaginate(t *testing.T) {
+	p := newPager(t, 10)
+	want := [][]string{
+		{"k01", "k02", "k03"},
+		{"k04", "k05", "k06"},
+		{"k07", "k08", "k09"},
+		{"k10"},
+	}
+
+	// Forward from the first page to the last.
+	var pages []storage.Page[string]
+	page := p.page("", "")
+	for {
+		pages = append(pages, page)
+		if page.Next == "" {
+			break
+		}
+		page = p.page(page.Next, "")
+	}
+	if len(pages) != len(want) {
+		t.Fatalf("%d pages forward, want %d", len(pages), len(want))
+	}
+	for i, page := range pages {
+		if !slices.Equal(page.Items, want[i]) {
+			t.Errorf("page %d = %v, want %v", i, page.Items, want[i])
+		}
+	}
+	if pages[0].Prev != "" {
+		t.Error("the first page has a Prev cursor")
+	}
+	for i, page := range pages[1:] {
+		if page.Prev == "" {
+			t.Errorf("page %d has no Prev cursor", i+1)
+		}
+	}
+
+	// Back from the last page to the first, through the same pages.
+	page = pages[len(pages)-1]
+	for i := len(pages) - 2; i >= 0; i-- {
+		page = p.page("", page.Prev)
+		if !slices.Equal(page.Items, want[i]) {
+			t.Errorf("page %d backward = %v, want %v", i, page.Items, want[i])
+		}
+		if page.Next == "" {
+			t.Errorf("page %d backward has no Next cursor", i)
+		}
+		if next := p.page(page.Next, ""); !slices.Equal(next.Items, want[i+1]) {
+			t.Errorf("Next of page %d backward = %v, want %v", i, next.Items, want[i+1])
+		}
+	}
+	if page.Prev != "" {
+		t.Error("the first page reached backward has a Prev cursor")
+	}
+}
+
+func TestPaginateSinglePage(t *testing.T) {
+	for _, n := range []int{0, 2, 3} {
+		page := newPager(t, n).page("", "")
+		if len(page.Items) != n || page.Next != "" || page.Prev != "" {
+			t.Errorf("%d items: page = %+v, want them all without cursors", n, page)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"strconv"
+	"strings"
+	"sync"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// isPostgres reports whether dbURL is a Postgres URL. Anything else is a SQLite file name.
+func isPostgres(dbURL string) bool {
+	scheme, _, ok := strings.Cut(dbURL, "://")
+	return ok && (strings.EqualFold(scheme, "postgres") || strings.EqualFold(scheme, "postgresql"))
+}
+
+func getPostgresDB[Queries any](
+	dbURL string, migrations embed.FS, factory func(tx DBTX) *Queries, o options,
+) (*DB[Queries], error) {
+	m, err := newMetrics(o.meter)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("pgx", dbURL)
+	if err != nil {
+		return nil, err
+	}
+	if err := migrate(db, Postgres, migrations, o); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	// Postgres handles concurrent writers itself, so readers and writers share the same pool and
+	// there is no writer mutex.
+	return &DB[Queries]{
+		factory: factory,
+		dialect: Postgres,
+		rddb:    db,
+		wrdb:    db,
+		metrics: m,
+		tracer:  newTracer(Postgres, m, o),
+	}, nil
+}
+
+// rebinder lets the queries sqlc generates for SQLite run on Postgres by rewriting their ?
+// placeholders to $1, $2...
+type rebinder struct {
+	tx DBTX
+}
+
+func (r rebinder) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return r.tx.ExecContext(ctx, rebind(query), args...)
+}
+
+func (r rebinder) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return r.tx.PrepareContext(ctx, rebind(query))
+}
+
+func (r rebinder) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return r.tx.QueryContext(ctx, rebind(query), args...)
+}
+
+func (r rebinder) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	return r.tx.QueryRowContext(ctx, rebind(query), args...)
+}
+
+// rebound caches rewritten queries. There is a fixed number of them, so it never grows unbounded.
+var rebound sync.Map
+
+func rebind(query string) string {
+	if q, ok := rebound.Load(query); ok {
+		return q.(string)
+	}
+
+	var sb strings.Builder
+	sb.Grow(len(query) + 8)
+	n := 0
+	for i := 0; i < len(query); i++ {
+		ch := query[i]
+		switch {
+		case ch == '\'' || ch == '"':
+			// Copy quoted strings and identifiers verbatim.
+			end := len(query)
+			if j := strings.IndexByte(query[i+1:], ch); j >= 0 {
+				end = i + j + 2
+			}
+			sb.WriteString(query[i:end])
+			i = end - 1
+		case ch == '-' && i+1 < len(query) && query[i+1] == '-':
+			// Same for comments, like the -- name: line sqlc adds to every query.
+			end := len(query)
+			if j := strings.IndexByte(query[i:], '\n'); j >= 0 {
+				end = i + j
+			}
+			sb.WriteString(query[i:end])
+			i = end - 1
+		case ch == '/' && i+1 < len(query) && query[i+1] == '*':
+			end := len(query)
+			if j := strings.Index(query[i+2:], "*/"); j >= 0 {
+				end = i + j + 4
+			}
+			sb.WriteString(query[i:end])
+			i = end - 1
+		case ch == '?':
+			n++
+			sb.WriteByte('$')
+			sb.WriteString(strconv.Itoa(n))
+		default:
+			sb.WriteByte(ch)
+		}
+	}
+
+	q := sb.String()
+	rebound.Store(query, q)
+	return q
+}
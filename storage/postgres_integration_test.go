@@ -0,0 +1,156 @@
+package storage_test
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/avalonbits/echo-template-service/storage"
+	"github.com/avalonbits/echo-template-service/storage/datastore"
+)
+
+// postgresURLEnv names the environment variable with the URL of a Postgres database the tests
+// can create schemas in. The Postgres tests are skipped without it.
+const postgresURLEnv = "TEST_POSTGRES_URL"
+
+func TestGetDBPicksDialect(t *testing.T) {
+	db, err := storage.GetDB(filepath.Join(t.TempDir(), "test.db"), datastore.Migrations, datastore.Factory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if db.Dialect() != storage.SQLite {
+		t.Errorf("Dialect = %s, want %s for a file name", db.Dialect(), storage.SQLite)
+	}
+}
+
+// postgresDB returns a database migrated in a schema of its own, dropped when the test ends.
+func postgresDB(t *testing.T) *storage.DB[datastore.Queries] {
+	t.Helper()
+	dbURL := os.Getenv(postgresURLEnv)
+	if dbURL == "" {
+		t.Skipf("%s not set", postgresURLEnv)
+	}
+
+	suffix := make([]byte, 6)
+	rand.Read(suffix)
+	schema := "test_" + hex.EncodeToString(suffix)
+
+	admin, err := sql.Open("pgx", dbURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+		admin.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if _, err := admin.Exec("DROP SCHEMA " + schema + " CASCADE"); err != nil {
+			t.Error(err)
+		}
+		admin.Close()
+	})
+
+	u, err := url.Parse(dbURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	q.Set("search_path", schema)
+	u.RawQuery = q.Encode()
+
+	db, err := storage.GetDB(u.String(), datastore.Migrations, datastore.Factory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestPostgresTransactions(t *testing.T) {
+	db := postgresDB(t)
+	ctx := context.Background()
+	if db.Dialect() != storage.Postgres {
+		t.Fatalf("Dialect = %s, want %s for a postgres URL", db.Dialect(), storage.Postgres)
+	}
+
+	err := db.Write(ctx, func(queries *datastore.Queries) error {
+		return createUser(ctx, queries, "alice")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !registered(t, db, "alice") {
+		t.Fatal("alice was not committed")
+	}
+
+	err = db.Write(ctx, func(queries *datastore.Queries) error {
+		return createUser(ctx, queries, "alice")
+	})
+	if !errors.Is(err, storage.ErrConstraint) {
+		t.Errorf("duplicate user = %v, want ErrConstraint", err)
+	}
+
+	err = db.Read(ctx, func(queries *datastore.Queries) error {
+		return createUser(ctx, queries, "bob")
+	})
+	if !errors.Is(err, storage.ErrReadOnly) {
+		t.Errorf("writing in Read = %v, want ErrReadOnly", err)
+	}
+
+	err = db.WriteTx(ctx, func(ctx context.Context, queries *datastore.Queries) error {
+		if err := createUser(ctx, queries, "carol"); err != nil {
+			return err
+		}
+		nested := db.Write(ctx, func(queries *datastore.Queries) error {
+			return createUser(ctx, queries, "alice")
+		})
+		if !errors.Is(nested, storage.ErrConstraint) {
+			t.Errorf("nested duplicate user = %v, want ErrConstraint", nested)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !registered(t, db, "carol") {
+		t.Error("carol was rolled back with the failed savepoint")
+	}
+}
+
+// TestPostgresQueries prepares every query sqlc generates code for against the Postgres schema,
+// which fails if a query uses a column or syntax the Postgres migrations don't have.
+func TestPostgresQueries(t *testing.T) {
+	db := postgresDB(t)
+	ctx := context.Background()
+
+	data, err := os.ReadFile(filepath.Join("datastore", "queries.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, query := range strings.Split(string(data), "-- name: ")[1:] {
+		name, _, _ := strings.Cut(query, " ")
+		if strings.HasPrefix(name, "Search") {
+			// Full-text search is SQLite only.
+			continue
+		}
+
+		err := db.WriteRaw(ctx, func(ctx context.Context, tx storage.DBTX) error {
+			stmt, err := tx.PrepareContext(ctx, "-- name: "+query)
+			if err != nil {
+				return err
+			}
+			return stmt.Close()
+		})
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
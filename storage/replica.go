@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// Replica is where the replicator stores database generations. It has object store semantics
+// (keys are slash separated paths) so it can be backed by a local directory or by an
+// S3-compatible bucket.
+type Replica interface {
+	Put(ctx context.Context, key string, r io.Reader) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// List returns the names directly under prefix, sorted. A missing prefix is not an error.
+	List(ctx context.Context, prefix string) ([]string, error)
+	// Delete removes key and everything under it.
+	Delete(ctx context.Context, key string) error
+}
+
+// DirReplica is a Replica on the local file system, rooted at the directory it names.
+type DirReplica string
+
+func (d DirReplica) path(key string) string {
+	return filepath.Join(string(d), filepath.FromSlash(key))
+}
+
+func (d DirReplica) Put(ctx context.Context, key string, r io.Reader) error {
+	dst := d.path(key)
+	if err := os.MkdirAll(filepath.Dir(dst), 0o750); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a crash never leaves a partial object behind.
+	f, err := os.CreateTemp(filepath.Dir(dst), ".put-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), dst)
+}
+
+func (d DirReplica) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return os.Open(d.path(key))
+}
+
+func (d DirReplica) List(ctx context.Context, prefix string) ([]string, error) {
+	entries, err := os.ReadDir(d.path(prefix))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if e.Name()[0] != '.' {
+			names = append(names, e.Name())
+		}
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+func (d DirReplica) Delete(ctx context.Context, key string) error {
+	return os.RemoveAll(d.path(key))
+}
@@ -0,0 +1,384 @@
+package storage_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/avalonbits/echo-template-service/storage"
+	"github.com/avalonbits/echo-template-service/storage/datastore"
+)
+
+// fakeS3 is a stand-in for an S3-compatible store serving a single bucket. It lists at most
+// pageSize entries per page so replicas have to follow continuation tokens.
+type fakeS3 struct {
+	bucket   string
+	pageSize int
+
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=access/") ||
+		!strings.Contains(auth, "/us-east-1/s3/aws4_request") || r.Header.Get("X-Amz-Date") == "" {
+		http.Error(w, "unsigned request", http.StatusForbidden)
+		return
+	}
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != f.bucket {
+		http.Error(w, "no such bucket", http.StatusNotFound)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case r.Method == http.MethodGet && key == "":
+		f.list(w, r)
+	case r.Method == http.MethodGet:
+		data, ok := f.objects[key]
+		if !ok {
+			http.Error(w, "no such key", http.StatusNotFound)
+			return
+		}
+		w.Write(data)
+	case r.Method == http.MethodPut:
+		if r.ContentLength < 0 {
+			http.Error(w, "missing content length", http.StatusLengthRequired)
+			return
+		}
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.objects[key] = data
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "unsupported", http.StatusMethodNotAllowed)
+	}
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	prefix, delimiter := q.Get("prefix"), q.Get("delimiter")
+
+	type entry struct {
+		name   string
+		prefix bool
+	}
+	seen := map[string]bool{}
+	var entries []entry
+	for key := range f.objects {
+		rest, ok := strings.CutPrefix(key, prefix)
+		if !ok {
+			continue
+		}
+		if delimiter != "" {
+			if dir, _, ok := strings.Cut(rest, delimiter); ok {
+				if name := prefix + dir + delimiter; !seen[name] {
+					seen[name] = true
+					entries = append(entries, entry{name, true})
+				}
+				continue
+			}
+		}
+		entries = append(entries, entry{key, false})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].name < entries[j].name })
+
+	start, _ := strconv.Atoi(q.Get("continuation-token"))
+	end := min(start+f.pageSize, len(entries))
+	type object struct {
+		Key string
+	}
+	type commonPrefix struct {
+		Prefix string
+	}
+	result := struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		Prefix                string
+		IsTruncated           bool
+		NextContinuationToken string         `xml:",omitempty"`
+		Contents              []object       `xml:"Contents"`
+		CommonPrefixes        []commonPrefix `xml:"CommonPrefixes"`
+	}{Prefix: prefix}
+	for _, e := range entries[start:end] {
+		if e.prefix {
+			result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{e.name})
+		} else {
+			result.Contents = append(result.Contents, object{e.name})
+		}
+	}
+	if end < len(entries) {
+		result.IsTruncated = true
+		result.NextContinuationToken = strconv.Itoa(end)
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+// s3Replica returns a replica in a bucket of a fakeS3 under a prefix, so keys of the bucket outside
+// of it must stay out of reach.
+func s3Replica(t *testing.T) storage.Replica {
+	t.Helper()
+	fake := &fakeS3{bucket: "bucket", pageSize: 2, objects: map[string][]byte{
+		"other/generations/x": []byte("not ours"),
+	}}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+
+	replica, err := storage.OpenReplica("s3://bucket/backups/household", storage.S3Options{
+		Endpoint:  srv.URL,
+		AccessKey: "access",
+		SecretKey: "secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return replica
+}
+
+var replicas = []struct {
+	name string
+	new  func(t *testing.T) storage.Replica
+}{
+	{"dir", func(t *testing.T) storage.Replica { return storage.DirReplica(t.TempDir()) }},
+	{"s3", s3Replica},
+}
+
+func put(t *testing.T, r storage.Replica, key string, body io.Reader) {
+	t.Helper()
+	if err := r.Put(context.Background(), key, body); err != nil {
+		t.Fatalf("Put(%s) = %v", key, err)
+	}
+}
+
+func get(t *testing.T, r storage.Replica, key string) (string, error) {
+	t.Helper()
+	rc, err := r.Get(context.Background(), key)
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data), nil
+}
+
+func list(t *testing.T, r storage.Replica, prefix string) []string {
+	t.Helper()
+	names, err := r.List(context.Background(), prefix)
+	if err != nil {
+		t.Fatalf("List(%s) = %v", prefix, err)
+	}
+	return names
+}
+
+func TestReplica(t *testing.T) {
+	ctx := context.Background()
+	for _, tt := range replicas {
+		t.Run(tt.name, func(t *testing.T) {
+			r := tt.new(t)
+
+			if names := list(t, r, "generations"); len(names) != 0 {
+				t.Errorf("List of a missing prefix = %v, want nothing", names)
+			}
+			if _, err := get(t, r, "generations/missing"); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("Get of a missing key = %v, want fs.ErrNotExist", err)
+			}
+
+			put(t, r, "generations/g1/snapshot.db", strings.NewReader("snapshot"))
+			// Not a Seeker: the S3 replica has to buffer it to know its length.
+			put(t, r, "generations/g1/wal/1.wal", io.MultiReader(strings.NewReader("wal-"), strings.NewReader("1")))
+			for _, name := range []string{"2.wal", "3.wal", "4.wal"} {
+				put(t, r, "generations/g1/wal/"+name, bytes.NewReader([]byte(name)))
+			}
+			put(t, r, "generations/g2/snapshot.db", strings.NewReader("old"))
+			put(t, r, "generations/g2/snapshot.db", strings.NewReader("new"))
+			put(t, r, "generations/g3", strings.NewReader("file"))
+
+			if data, err := get(t, r, "generations/g1/wal/1.wal"); err != nil || data != "wal-1" {
+				t.Errorf("Get = %q, %v; want %q", data, err, "wal-1")
+			}
+			if data, err := get(t, r, "generations/g2/snapshot.db"); err != nil || data != "new" {
+				t.Errorf("Get of an overwritten key = %q, %v; want %q", data, err, "new")
+			}
+
+			if names, want := list(t, r, "generations"), []string{"g1", "g2", "g3"}; !slices.Equal(names, want) {
+				t.Errorf("List(generations) = %v, want %v", names, want)
+			}
+			if names, want := list(t, r, "generations/g1"), []string{"snapshot.db", "wal"}; !slices.Equal(names, want) {
+				t.Errorf("List(generations/g1) = %v, want %v", names, want)
+			}
+			want := []string{"1.wal", "2.wal", "3.wal", "4.wal"}
+			if names := list(t, r, "generations/g1/wal"); !slices.Equal(names, want) {
+				t.Errorf("List(generations/g1/wal) = %v, want %v", names, want)
+			}
+
+			if err := r.Delete(ctx, "generations/g1"); err != nil {
+				t.Fatal(err)
+			}
+			if err := r.Delete(ctx, "generations/g3"); err != nil {
+				t.Fatal(err)
+			}
+			if err := r.Delete(ctx, "generations/missing"); err != nil {
+				t.Errorf("Delete of a missing key = %v, want nil", err)
+			}
+			if names, want := list(t, r, "generations"), []string{"g2"}; !slices.Equal(names, want) {
+				t.Errorf("List after Delete = %v, want %v", names, want)
+			}
+			if _, err := get(t, r, "generations/g1/wal/1.wal"); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("Get of a deleted key = %v, want fs.ErrNotExist", err)
+			}
+		})
+	}
+}
+
+func TestOpenReplica(t *testing.T) {
+	s3 := storage.S3Options{AccessKey: "access", SecretKey: "secret"}
+	if r, err := storage.OpenReplica("/var/lib/household/replica", s3); err != nil ||
+		r != storage.DirReplica("/var/lib/household/replica") {
+		t.Errorf("OpenReplica(dir) = %v, %v; want a DirReplica", r, err)
+	}
+	if r, err := storage.OpenReplica("s3://bucket/prefix", s3); err != nil {
+		t.Errorf("OpenReplica(s3) = %v", err)
+	} else if _, ok := r.(*storage.S3Replica); !ok {
+		t.Errorf("OpenReplica(s3) = %T, want an S3Replica", r)
+	}
+	if _, err := storage.OpenReplica("s3:///prefix", s3); err == nil {
+		t.Error("OpenReplica without a bucket succeeded")
+	}
+	if _, err := storage.OpenReplica("s3://bucket", storage.S3Options{}); err == nil {
+		t.Error("OpenReplica without credentials succeeded")
+	}
+}
+
+// waitForReplicated waits for the replicator to ship every WAL frame of the database at file to
+// r, so what was committed so far can be restored.
+func waitForReplicated(t *testing.T, r storage.Replica, file string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		var size int64
+		if wal, err := os.Stat(file + "-wal"); err == nil {
+			size = wal.Size()
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			t.Fatal(err)
+		}
+
+		// The WAL is truncated when a generation starts, so it is all in the newest one.
+		generations := list(t, r, "generations")
+		var shipped int64
+		if len(generations) > 0 {
+			prefix := path.Join("generations", generations[len(generations)-1], "wal")
+			for _, s := range list(t, r, prefix) {
+				data, err := get(t, r, path.Join(prefix, s))
+				if err != nil {
+					t.Fatal(err)
+				}
+				shipped += int64(len(data))
+			}
+		}
+		if len(generations) > 0 && shipped == size {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("the WAL was not replicated")
+}
+
+func TestRestoreReplica(t *testing.T) {
+	ctx := context.Background()
+	for _, tt := range replicas {
+		t.Run(tt.name, func(t *testing.T) {
+			r := tt.new(t)
+			dir := t.TempDir()
+			src := filepath.Join(dir, "src.db")
+
+			db, err := storage.GetDB(src, datastore.Migrations, datastore.Factory,
+				storage.WithReplica(r, 10*time.Millisecond, time.Hour))
+			if err != nil {
+				t.Fatal(err)
+			}
+			write := func(id string) {
+				t.Helper()
+				err := db.Write(ctx, func(queries *datastore.Queries) error {
+					return createUser(ctx, queries, id)
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// Let the first generation start, so the rows are replayed from the WAL.
+			waitForReplicated(t, r, src)
+			write("alice")
+			write("bob")
+			waitForReplicated(t, r, src)
+
+			// Segments are named after the millisecond they were shipped in.
+			time.Sleep(5 * time.Millisecond)
+			at := time.Now()
+			time.Sleep(5 * time.Millisecond)
+
+			write("carol")
+			// Closing ships what was committed since the last sync.
+			if err := db.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, restore := range []struct {
+				at   time.Time
+				want map[string]bool
+			}{
+				{at, map[string]bool{"alice": true, "bob": true, "carol": false}},
+				{time.Now(), map[string]bool{"alice": true, "bob": true, "carol": true}},
+			} {
+				dst := filepath.Join(dir, "restored.db")
+				if err := storage.RestoreReplica(ctx, r, dst, restore.at); err != nil {
+					t.Fatal(err)
+				}
+				restored, err := storage.GetDB(dst, datastore.Migrations, datastore.Factory)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for id, want := range restore.want {
+					if got := registered(t, restored, id); got != want {
+						t.Errorf("restored as of %s: %s registered = %t, want %t",
+							restore.at.Format(time.RFC3339Nano), id, got, want)
+					}
+				}
+				if err := restored.Close(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := storage.RestoreReplica(ctx, r, filepath.Join(dir, "early.db"), time.Unix(0, 0)); err == nil {
+				t.Error("restoring to before the first generation succeeded")
+			}
+		})
+	}
+}
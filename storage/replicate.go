@@ -0,0 +1,428 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Continuous replication works like Litestream: each time the replicator starts it creates a new
+// generation, made of a snapshot of the database followed by every WAL frame committed after it.
+// SQLite's automatic checkpoints are disabled so frames are never overwritten before they are
+// copied; the replicator checkpoints itself once they are safe in the replica.
+//
+// Replica layout:
+//
+//	generations/<generation>/snapshot.db
+//	generations/<generation>/wal/<index>-<offset>-<unix ms>.wal
+//
+// index counts how many times the WAL was reset since the snapshot and offset is where in the WAL
+// the segment starts. The first segment of each index includes the WAL header.
+
+const (
+	replicatedDriver = "sqlite3_replicated"
+	generationFormat = "20060102T150405.000Z"
+	walHeaderSize    = 32
+	walFrameHeader   = 24
+	checkpointSize   = 4 << 20
+)
+
+func init() {
+	sql.Register(replicatedDriver, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			_, err := conn.Exec("PRAGMA wal_autocheckpoint = 0", nil)
+			return err
+		},
+	})
+}
+
+// WithReplica continuously replicates a SQLite database to replica, syncing new transactions
+// every interval and keeping generations for at least retention. It is ignored for Postgres.
+func WithReplica(replica Replica, interval, retention time.Duration) Option {
+	return func(o *options) {
+		o.replica = replica
+		o.replicaInterval = interval
+		o.replicaRetention = retention
+	}
+}
+
+type replicator struct {
+	mu      *sync.Mutex
+	wrdb    *sql.DB
+	walPath string
+
+	replica   Replica
+	interval  time.Duration
+	retention time.Duration
+
+	generation string
+	index      int
+	offset     int64
+	salt       []byte
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newReplicator(
+	mu *sync.Mutex, wrdb *sql.DB, dbName string, o options) *replicator {
+	return &replicator{
+		mu:        mu,
+		wrdb:      wrdb,
+		walPath:   dbName + "-wal",
+		replica:   o.replica,
+		interval:  o.replicaInterval,
+		retention: o.replicaRetention,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+func (r *replicator) start() {
+	go r.loop()
+}
+
+// close stops replication after copying whatever was committed so far.
+func (r *replicator) close() error {
+	close(r.stop)
+	<-r.done
+	return r.sync(context.Background())
+}
+
+func (r *replicator) loop() {
+	defer close(r.done)
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	var lastPrune time.Time
+	for {
+		ctx := context.Background()
+		if err := r.sync(ctx); err != nil {
+			log.Printf("error replicating database: %v", err)
+			// Frames might be missing from the replica, so start over with a new generation.
+			r.generation = ""
+		}
+		if r.generation != "" && time.Since(lastPrune) > time.Hour {
+			lastPrune = time.Now()
+			if err := r.prune(ctx); err != nil {
+				log.Printf("error pruning replica generations: %v", err)
+			}
+		}
+
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *replicator) sync(ctx context.Context) error {
+	if r.generation == "" {
+		return r.newGeneration(ctx)
+	}
+
+	r.mu.Lock()
+	segment, offset, err := r.readWAL()
+	checkpointed := false
+	if err == nil && offset >= checkpointSize {
+		checkpointed, err = r.checkpoint(ctx)
+	}
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if len(segment) > 0 {
+		name := fmt.Sprintf("%08d-%016d-%d.wal", r.index, r.offset, time.Now().UnixMilli())
+		key := path.Join("generations", r.generation, "wal", name)
+		if err := r.replica.Put(ctx, key, bytes.NewReader(segment)); err != nil {
+			return err
+		}
+	}
+
+	r.offset = offset
+	if checkpointed {
+		r.index++
+		r.offset = 0
+		r.salt = nil
+	}
+	return nil
+}
+
+// newGeneration checkpoints the WAL away and snapshots the database, so the generation starts
+// with an empty WAL at index 0.
+func (r *replicator) newGeneration(ctx context.Context) error {
+	tmp, err := os.CreateTemp("", "snapshot-*.db")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	r.mu.Lock()
+	ok, err := r.checkpoint(ctx)
+	if err == nil && !ok {
+		err = fmt.Errorf("could not checkpoint the WAL, readers are still using it")
+	}
+	if err == nil {
+		err = backup(ctx, r.wrdb, tmp.Name())
+	}
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(tmp.Name())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	generation := time.Now().UTC().Format(generationFormat)
+	key := path.Join("generations", generation, "snapshot.db")
+	if err := r.replica.Put(ctx, key, f); err != nil {
+		return err
+	}
+
+	r.generation = generation
+	r.index = 0
+	r.offset = 0
+	r.salt = nil
+	return nil
+}
+
+// checkpoint copies the WAL into the database and truncates it. It returns false if it could not
+// because of readers still using the WAL. Must be called with r.mu held.
+func (r *replicator) checkpoint(ctx context.Context) (bool, error) {
+	var busy, logFrames, checkpointed int
+	err := r.wrdb.QueryRowContext(ctx, "PRAGMA wal_checkpoint(TRUNCATE)").
+		Scan(&busy, &logFrames, &checkpointed)
+	if err != nil {
+		return false, err
+	}
+	return busy == 0, nil
+}
+
+// readWAL returns the WAL bytes committed since r.offset and the offset right after them. Must be
+// called with r.mu held so no writer is appending to the WAL.
+func (r *replicator) readWAL() ([]byte, int64, error) {
+	f, err := os.Open(r.walPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, r.offset, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	header := make([]byte, walHeaderSize)
+	if _, err := f.ReadAt(header, 0); err == io.EOF {
+		return nil, r.offset, nil
+	} else if err != nil {
+		return nil, 0, err
+	}
+	salt := header[16:24]
+	if r.salt != nil && !bytes.Equal(salt, r.salt) {
+		return nil, 0, fmt.Errorf("WAL was reset outside of the replicator")
+	}
+	pageSize := int64(binary.BigEndian.Uint32(header[8:12]))
+	frameSize := walFrameHeader + pageSize
+
+	if _, err := f.Seek(r.offset, io.SeekStart); err != nil {
+		return nil, 0, err
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Frames past the last commit belong to a transaction in progress, and frames with a different
+	// salt are leftovers from before the WAL was last reset.
+	start := max(r.offset, walHeaderSize) - r.offset
+	end := start
+	for pos := start; pos+frameSize <= int64(len(data)); pos += frameSize {
+		frame := data[pos : pos+walFrameHeader]
+		if !bytes.Equal(frame[8:16], salt) {
+			break
+		}
+		if binary.BigEndian.Uint32(frame[4:8]) != 0 {
+			end = pos + frameSize
+		}
+	}
+
+	r.salt = bytes.Clone(salt)
+	if end == start {
+		return nil, r.offset, nil
+	}
+	return data[:end], r.offset + end, nil
+}
+
+// prune deletes generations older than the retention period, always keeping the current one.
+func (r *replicator) prune(ctx context.Context) error {
+	generations, err := r.replica.List(ctx, "generations")
+	if err != nil {
+		return err
+	}
+
+	cutoff := time.Now().Add(-r.retention)
+	for _, g := range generations {
+		t, err := time.Parse(generationFormat, g)
+		if err != nil || g == r.generation || t.After(cutoff) {
+			continue
+		}
+		if err := r.replica.Delete(ctx, path.Join("generations", g)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RestoreReplica rebuilds the database as of at from the newest generation in replica that
+// started before it, and swaps it in place of the SQLite database at dst like Restore does.
+func RestoreReplica(ctx context.Context, replica Replica, dst string, at time.Time) error {
+	if isPostgres(dst) {
+		return ErrNotSQLite
+	}
+
+	generations, err := replica.List(ctx, "generations")
+	if err != nil {
+		return err
+	}
+	generation := ""
+	for _, g := range generations {
+		t, err := time.Parse(generationFormat, g)
+		if err == nil && !t.After(at) {
+			generation = g
+		}
+	}
+	if generation == "" {
+		return fmt.Errorf("no generation in the replica started before %s", at.Format(time.RFC3339))
+	}
+
+	tmp := dst + ".restore"
+	if err := fetch(ctx, replica, path.Join("generations", generation, "snapshot.db"), tmp); err != nil {
+		return err
+	}
+	if err := replay(ctx, replica, generation, tmp, at); err != nil {
+		removeDB(tmp)
+		return err
+	}
+	if err := VerifyIntegrity(ctx, tmp); err != nil {
+		removeDB(tmp)
+		return err
+	}
+	return swap(tmp, dst)
+}
+
+// replay applies the generation's WAL segments up to at to the database in file, one WAL index
+// at a time: SQLite recovers the WAL when opening the database and we checkpoint it in.
+func replay(ctx context.Context, replica Replica, generation, file string, at time.Time) error {
+	segments, err := replica.List(ctx, path.Join("generations", generation, "wal"))
+	if err != nil {
+		return err
+	}
+
+	index := -1
+	var wal bytes.Buffer
+	for _, s := range segments {
+		var idx int
+		var offset, ms int64
+		if _, err := fmt.Sscanf(s, "%08d-%016d-%d.wal", &idx, &offset, &ms); err != nil {
+			continue
+		}
+		if time.UnixMilli(ms).After(at) {
+			break
+		}
+
+		if idx != index {
+			if err := checkpointWAL(ctx, file, wal.Bytes()); err != nil {
+				return err
+			}
+			if offset != 0 {
+				return fmt.Errorf("segment %s does not start its WAL", s)
+			}
+			index = idx
+			wal.Reset()
+		}
+		if int64(wal.Len()) != offset {
+			return fmt.Errorf("segment %s does not follow the previous one", s)
+		}
+
+		rc, err := replica.Get(ctx, path.Join("generations", generation, "wal", s))
+		if err != nil {
+			return err
+		}
+		_, err = wal.ReadFrom(rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return checkpointWAL(ctx, file, wal.Bytes())
+}
+
+func checkpointWAL(ctx context.Context, file string, wal []byte) error {
+	if len(wal) == 0 {
+		return nil
+	}
+	os.Remove(file + "-shm")
+	if err := os.WriteFile(file+"-wal", wal, 0o600); err != nil {
+		return err
+	}
+
+	db, err := sql.Open("sqlite3", fmt.Sprintf(writeDSN, file))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var busy, logFrames, checkpointed int
+	err = db.QueryRowContext(ctx, "PRAGMA wal_checkpoint(TRUNCATE)").
+		Scan(&busy, &logFrames, &checkpointed)
+	if err != nil {
+		return err
+	}
+	if busy != 0 || logFrames != checkpointed {
+		return fmt.Errorf("could not apply WAL: %d of %d frames checkpointed", checkpointed, logFrames)
+	}
+	return nil
+}
+
+func fetch(ctx context.Context, replica Replica, key, dst string) error {
+	rc, err := replica.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	removeDB(dst)
+	f, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, rc); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func removeDB(file string) {
+	for _, suffix := range []string{"", "-wal", "-shm"} {
+		os.Remove(file + suffix)
+	}
+}
@@ -0,0 +1,341 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3Options says how to reach an S3-compatible object store.
+type S3Options struct {
+	// Endpoint is the base URL of the store, like https://s3.us-east-1.amazonaws.com or the
+	// address of a MinIO server. It defaults to the AWS endpoint of Region.
+	Endpoint  string
+	Region    string
+	AccessKey string
+	SecretKey string
+	// Client defaults to a client with a one minute timeout.
+	Client *http.Client
+}
+
+// OpenReplica returns the replica target names: a bucket and optional prefix given as an
+// s3://bucket/prefix URL, reached with s3, or else a local directory.
+func OpenReplica(target string, s3 S3Options) (Replica, error) {
+	rest, ok := strings.CutPrefix(target, "s3://")
+	if !ok {
+		return DirReplica(target), nil
+	}
+	bucket, prefix, _ := strings.Cut(rest, "/")
+	if bucket == "" {
+		return nil, fmt.Errorf("invalid replica %q: no bucket", target)
+	}
+	return NewS3Replica(bucket, prefix, s3)
+}
+
+// S3Replica is a Replica in a bucket of an S3-compatible object store, under an optional key
+// prefix. Buckets are addressed path style (endpoint/bucket/key), which every S3-compatible store
+// supports, and requests are signed with AWS Signature Version 4.
+type S3Replica struct {
+	endpoint *url.URL
+	bucket   string
+	prefix   string
+	region   string
+	access   string
+	secret   string
+	client   *http.Client
+}
+
+func NewS3Replica(bucket, prefix string, o S3Options) (*S3Replica, error) {
+	if o.Region == "" {
+		o.Region = "us-east-1"
+	}
+	if o.Endpoint == "" {
+		o.Endpoint = "https://s3." + o.Region + ".amazonaws.com"
+	}
+	endpoint, err := url.Parse(strings.TrimSuffix(o.Endpoint, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", o.Endpoint)
+	}
+	if o.AccessKey == "" || o.SecretKey == "" {
+		return nil, fmt.Errorf("S3 replica needs an access key and a secret key")
+	}
+	if o.Client == nil {
+		o.Client = &http.Client{Timeout: time.Minute}
+	}
+
+	prefix = strings.Trim(prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	return &S3Replica{
+		endpoint: endpoint,
+		bucket:   bucket,
+		prefix:   prefix,
+		region:   o.Region,
+		access:   o.AccessKey,
+		secret:   o.SecretKey,
+		client:   o.Client,
+	}, nil
+}
+
+func (s *S3Replica) Put(ctx context.Context, key string, r io.Reader) error {
+	// S3 needs the length of the object up front: the replicator passes files and buffers, which
+	// can tell without reading them. Anything else is buffered.
+	body, ok := r.(io.ReadSeeker)
+	if !ok {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	size, err := body.Seek(0, io.SeekEnd)
+	if err == nil {
+		_, err = body.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		return err
+	}
+
+	res, err := s.do(ctx, http.MethodPut, s.prefix+key, nil, io.NopCloser(body), size)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+func (s *S3Replica) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	res, err := s.do(ctx, http.MethodGet, s.prefix+key, nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	return res.Body, nil
+}
+
+func (s *S3Replica) List(ctx context.Context, prefix string) ([]string, error) {
+	var names []string
+	err := s.list(ctx, s.prefix+strings.TrimSuffix(prefix, "/")+"/", "/", func(r listResult) {
+		for _, p := range r.CommonPrefixes {
+			names = append(names, strings.TrimSuffix(p.Prefix[len(r.Prefix):], "/"))
+		}
+		for _, c := range r.Contents {
+			names = append(names, c.Key[len(r.Prefix):])
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+func (s *S3Replica) Delete(ctx context.Context, key string) error {
+	keys := []string{s.prefix + key}
+	err := s.list(ctx, s.prefix+strings.TrimSuffix(key, "/")+"/", "", func(r listResult) {
+		for _, c := range r.Contents {
+			keys = append(keys, c.Key)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, k := range keys {
+		res, err := s.do(ctx, http.MethodDelete, k, nil, nil, 0)
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+	}
+	return nil
+}
+
+type listResult struct {
+	Prefix                string `xml:"Prefix"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+	Contents              []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	CommonPrefixes []struct {
+		Prefix string `xml:"Prefix"`
+	} `xml:"CommonPrefixes"`
+}
+
+// list calls f with every page of the objects whose keys start with prefix, grouped by delimiter
+// if it is not empty.
+func (s *S3Replica) list(ctx context.Context, prefix, delimiter string, f func(listResult)) error {
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if delimiter != "" {
+			query.Set("delimiter", delimiter)
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		res, err := s.do(ctx, http.MethodGet, "", query, nil, 0)
+		if err != nil {
+			return err
+		}
+		var result listResult
+		err = xml.NewDecoder(res.Body).Decode(&result)
+		res.Body.Close()
+		if err != nil {
+			return fmt.Errorf("error decoding S3 listing: %w", err)
+		}
+		result.Prefix = prefix
+
+		f(result)
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// do sends a signed request for key in the bucket, or for the bucket itself if key is empty. A
+// 404 for an object is returned as fs.ErrNotExist, like DirReplica does.
+func (s *S3Replica) do(
+	ctx context.Context,
+	method, key string,
+	query url.Values,
+	body io.ReadCloser,
+	size int64,
+) (*http.Response, error) {
+	path := "/" + s.bucket
+	if key != "" {
+		path += "/" + key
+	}
+	u := *s.endpoint
+	u.Path = s.endpoint.Path + path
+	u.RawPath = s.endpoint.EscapedPath() + escapePath(path)
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	s.sign(req, time.Now())
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode >= 200 && res.StatusCode <= 299 {
+		return res, nil
+	}
+
+	msg, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+	res.Body.Close()
+	if res.StatusCode == http.StatusNotFound && key != "" {
+		return nil, fmt.Errorf("%s %s: %w", method, key, fs.ErrNotExist)
+	}
+	return nil, fmt.Errorf("%s %s: S3 returned %s: %s", method, path, res.Status, bytes.TrimSpace(msg))
+}
+
+// unsignedPayload skips hashing bodies, which would mean reading snapshots twice. Requests are
+// still signed, and TLS protects the body.
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// sign adds AWS Signature Version 4 headers to req.
+func (s *S3Replica) sign(req *http.Request, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	day := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", unsignedPayload)
+
+	signed := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:" + unsignedPayload,
+		"x-amz-date:" + amzDate,
+		"",
+		strings.Join(signed, ";"),
+		unsignedPayload,
+	}, "\n")
+
+	scope := day + "/" + s.region + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.secret), day)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.access, scope, strings.Join(signed, ";"), signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// escapePath percent-encodes everything in path but unreserved characters and slashes, as
+// Signature Version 4 expects.
+func escapePath(path string) string {
+	var sb strings.Builder
+	for i := 0; i < len(path); i++ {
+		ch := path[i]
+		if ch == '/' || unreserved(ch) {
+			sb.WriteByte(ch)
+		} else {
+			fmt.Fprintf(&sb, "%%%02X", ch)
+		}
+	}
+	return sb.String()
+}
+
+// canonicalQuery encodes query sorted by key, with the escaping Signature Version 4 expects.
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, escapeQuery(k)+"="+escapeQuery(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func escapeQuery(s string) string {
+	return strings.ReplaceAll(escapePath(s), "/", "%2F")
+}
+
+func unreserved(ch byte) bool {
+	return 'A' <= ch && ch <= 'Z' || 'a' <= ch && ch <= 'z' || '0' <= ch && ch <= '9' ||
+		ch == '-' || ch == '_' || ch == '.' || ch == '~'
+}
@@ -0,0 +1,47 @@
+//go:build sqlite_fts5 || fts5
+
+package search
+
+import (
+	"context"
+	_ "embed"
+
+	"github.com/avalonbits/echo-template-service/storage"
+	"github.com/avalonbits/echo-template-service/storage/datastore"
+)
+
+//go:embed schema.sql
+var schema string
+
+// createIndexes creates the indexes in schema and the triggers that keep them in sync. An index
+// is rebuilt from its table when any of its triggers was missing, since changes made without it
+// are not in the index: that is the case for new indexes, and after a binary built without FTS5
+// dropped the triggers.
+func createIndexes(ctx context.Context, db *storage.DB[datastore.Queries]) error {
+	return db.WriteRaw(ctx, func(ctx context.Context, tx storage.DBTX) error {
+		stale := []string{}
+		for index, triggers := range indexes {
+			var found int
+			err := tx.QueryRowContext(ctx,
+				"SELECT count(*) FROM sqlite_master WHERE type = 'trigger' AND name IN (?, ?, ?)",
+				triggers[0], triggers[1], triggers[2]).Scan(&found)
+			if err != nil {
+				return err
+			}
+			if found < len(triggers) {
+				stale = append(stale, index)
+			}
+		}
+
+		if _, err := tx.ExecContext(ctx, schema); err != nil {
+			return err
+		}
+		for _, index := range stale {
+			_, err := tx.ExecContext(ctx, "INSERT INTO "+index+"("+index+") VALUES ('rebuild')")
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
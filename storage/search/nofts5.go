@@ -0,0 +1,30 @@
+//go:build !sqlite_fts5 && !fts5
+
+package search
+
+import (
+	"context"
+
+	"github.com/avalonbits/echo-template-service/storage"
+	"github.com/avalonbits/echo-template-service/storage/datastore"
+)
+
+// createIndexes fails without FTS5. A binary built with it may have created the indexes in this
+// database, and the triggers that keep them in sync would fail every write to their tables here,
+// so they are dropped. Binaries built with FTS5 rebuild the indexes when they recreate them.
+func createIndexes(ctx context.Context, db *storage.DB[datastore.Queries]) error {
+	err := db.WriteRaw(ctx, func(ctx context.Context, tx storage.DBTX) error {
+		for _, triggers := range indexes {
+			for _, trigger := range triggers {
+				if _, err := tx.ExecContext(ctx, "DROP TRIGGER IF EXISTS "+trigger); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return ErrNoFTS5
+}
@@ -0,0 +1,45 @@
+-- External content FTS5 indexes: the text lives in Person and Notification and the triggers keep
+-- the indexes in sync with it. storage/search creates them when the binary is built with FTS5.
+CREATE VIRTUAL TABLE IF NOT EXISTS PersonSearch USING fts5(
+    handle,
+    display_name,
+    content='Person',
+    tokenize='unicode61 remove_diacritics 2',
+    prefix='2 3'
+);
+
+CREATE TRIGGER IF NOT EXISTS person_search_insert AFTER INSERT ON Person BEGIN
+    INSERT INTO PersonSearch(rowid, handle, display_name)
+        VALUES (new.rowid, new.handle, new.display_name);
+END;
+CREATE TRIGGER IF NOT EXISTS person_search_delete AFTER DELETE ON Person BEGIN
+    INSERT INTO PersonSearch(PersonSearch, rowid, handle, display_name)
+        VALUES ('delete', old.rowid, old.handle, old.display_name);
+END;
+CREATE TRIGGER IF NOT EXISTS person_search_update AFTER UPDATE OF handle, display_name ON Person BEGIN
+    INSERT INTO PersonSearch(PersonSearch, rowid, handle, display_name)
+        VALUES ('delete', old.rowid, old.handle, old.display_name);
+    INSERT INTO PersonSearch(rowid, handle, display_name)
+        VALUES (new.rowid, new.handle, new.display_name);
+END;
+
+CREATE VIRTUAL TABLE IF NOT EXISTS NotificationSearch USING fts5(
+    title,
+    body,
+    content='Notification',
+    tokenize='unicode61 remove_diacritics 2',
+    prefix='2 3'
+);
+
+CREATE TRIGGER IF NOT EXISTS ntf_search_insert AFTER INSERT ON Notification BEGIN
+    INSERT INTO NotificationSearch(rowid, title, body) VALUES (new.rowid, new.title, new.body);
+END;
+CREATE TRIGGER IF NOT EXISTS ntf_search_delete AFTER DELETE ON Notification BEGIN
+    INSERT INTO NotificationSearch(NotificationSearch, rowid, title, body)
+        VALUES ('delete', old.rowid, old.title, old.body);
+END;
+CREATE TRIGGER IF NOT EXISTS ntf_search_update AFTER UPDATE OF title, body ON Notification BEGIN
+    INSERT INTO NotificationSearch(NotificationSearch, rowid, title, body)
+        VALUES ('delete', old.rowid, old.title, old.body);
+    INSERT INTO NotificationSearch(rowid, title, body) VALUES (new.rowid, new.title, new.body);
+END;
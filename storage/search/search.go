@@ -0,0 +1,171 @@
+package search
+
+import (
+	"context"
+	"errors"
+	"html"
+	"html/template"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/avalonbits/echo-template-service/storage"
+	"github.com/avalonbits/echo-template-service/storage/datastore"
+)
+
+// Source is a kind of content that can be searched.
+type Source string
+
+const (
+	// People searches every person by handle and display name. Only meant for admins.
+	People Source = "people"
+	// Notifications searches the in-app notifications of the person searching.
+	Notifications Source = "notifications"
+)
+
+// Query is what to search for and where.
+type Query struct {
+	// Text is what the user typed. Every word must match, and the last one matches as a prefix
+	// so results show up while typing.
+	Text    string
+	Sources []Source
+	// Pid is the person searching, which scopes sources holding personal content.
+	Pid   string
+	Limit int
+}
+
+// Result is a match, best ones first within each source.
+type Result struct {
+	Source Source
+	ID     string
+	Title  string
+	// Snippet is the matching text with the matched terms in <mark>.
+	Snippet template.HTML
+	// Rank is the bm25 score of the match. Lower is better.
+	Rank float64
+	Time time.Time
+}
+
+// ErrNoFTS5 means the binary was built without SQLite's FTS5, which needs -tags fts5.
+var ErrNoFTS5 = errors.New("built without FTS5")
+
+// indexes are the FTS5 tables in schema.sql, with the triggers that keep each in sync with the
+// table it indexes.
+var indexes = map[string][3]string{
+	"PersonSearch":       {"person_search_insert", "person_search_delete", "person_search_update"},
+	"NotificationSearch": {"ntf_search_insert", "ntf_search_delete", "ntf_search_update"},
+}
+
+// Service runs full-text queries over the FTS5 indexes in schema.sql. Triggers keep the indexes
+// in sync with their tables, so there is nothing to do when data changes.
+//
+// The tree has no household lists yet, so notifications are indexed for members instead of list
+// items; a new source needs an index and triggers in schema.sql, and a query.
+type Service struct {
+	db *storage.DB[datastore.Queries]
+}
+
+// New creates the FTS5 indexes if needed and returns a search service. The indexes only exist on
+// SQLite, with binaries built with FTS5: New returns storage.ErrNotSQLite or ErrNoFTS5 otherwise.
+func New(ctx context.Context, db *storage.DB[datastore.Queries]) (*Service, error) {
+	if db.Dialect() != storage.SQLite {
+		return nil, storage.ErrNotSQLite
+	}
+	if err := createIndexes(ctx, db); err != nil {
+		return nil, err
+	}
+	return &Service{db: db}, nil
+}
+
+func (s *Service) Search(ctx context.Context, q Query) ([]Result, error) {
+	match := MatchExpr(q.Text)
+	if match == "" {
+		return nil, nil
+	}
+	limit := int64(q.Limit)
+	if limit <= 0 {
+		limit = 20
+	}
+
+	var results []Result
+	err := s.db.Read(ctx, func(queries *datastore.Queries) error {
+		for _, source := range q.Sources {
+			switch source {
+			case People:
+				rows, err := queries.SearchPeople(ctx, datastore.SearchPeopleParams{
+					Query: match,
+					Limit: limit,
+				})
+				if err != nil {
+					return err
+				}
+				for _, row := range rows {
+					title := "@" + row.Handle
+					if row.DisplayName.String != "" {
+						title = row.DisplayName.String + " (@" + row.Handle + ")"
+					}
+					results = append(results, Result{
+						Source:  People,
+						ID:      row.ID,
+						Title:   title,
+						Snippet: highlight(row.Snippet),
+						Rank:    row.Rank,
+					})
+				}
+
+			case Notifications:
+				rows, err := queries.SearchNotifications(ctx, datastore.SearchNotificationsParams{
+					Query: match,
+					Pid:   q.Pid,
+					Limit: limit,
+				})
+				if err != nil {
+					return err
+				}
+				for _, row := range rows {
+					created, _ := time.Parse(time.RFC3339, row.CreatedAt)
+					results = append(results, Result{
+						Source:  Notifications,
+						ID:      row.ID,
+						Title:   row.Title,
+						Snippet: highlight(row.Snippet),
+						Rank:    row.Rank,
+						Time:    created,
+					})
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// MatchExpr turns what a user typed into an FTS5 query: every word is quoted so no character has
+// a special meaning, they are all required, and the last one is a prefix. It returns "" if there
+// is nothing to search for.
+func MatchExpr(text string) string {
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r) && r != '_'
+	})
+	if len(words) == 0 {
+		return ""
+	}
+
+	terms := make([]string, len(words))
+	for i, w := range words {
+		terms[i] = `"` + w + `"`
+	}
+	terms[len(terms)-1] += "*"
+	return strings.Join(terms, " ")
+}
+
+// highlight escapes a snippet and turns the markers the queries put around matches into <mark>.
+func highlight(snippet string) template.HTML {
+	escaped := html.EscapeString(snippet)
+	escaped = strings.ReplaceAll(escaped, "\x02", "<mark>")
+	escaped = strings.ReplaceAll(escaped, "\x03", "</mark>")
+	return template.HTML(escaped)
+}
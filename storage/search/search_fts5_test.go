@@ -0,0 +1,114 @@
+//go:build sqlite_fts5 || fts5
+
+package search
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/avalonbits/echo-template-service/storage"
+	"github.com/avalonbits/echo-template-service/storage/datastore"
+)
+
+func testService(t *testing.T) *Service {
+	t.Helper()
+	db := storage.TestDB(datastore.Migrations, datastore.Factory)
+	t.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			t.Error(err)
+		}
+	})
+	s, err := New(context.Background(), db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	err = db.Write(ctx, func(queries *datastore.Queries) error {
+		for _, id := range []string{"alice", "bob"} {
+			err := queries.CreateUser(ctx, datastore.CreateUserParams{
+				ID:        id,
+				Handle:    id,
+				CreatedAt: "2024-01-01T00:00:00Z",
+				Password:  []byte("password"),
+				Salt:      []byte("salt"),
+			})
+			if err != nil {
+				return err
+			}
+		}
+		for _, n := range []datastore.CreateNotificationParams{
+			{ID: "n1", Pid: "alice", Title: "Shopping", Body: `buy "oat" milk <script>alert(1)</script>`},
+			{ID: "n2", Pid: "bob", Title: "Shopping", Body: "buy milk"},
+		} {
+			n.Event, n.Inapp, n.CreatedAt = "account.signin", true, "2024-01-01T00:00:00Z"
+			if err := queries.CreateNotification(ctx, n); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestSearchNotifications(t *testing.T) {
+	s := testService(t)
+	ctx := context.Background()
+
+	results, err := s.Search(ctx, Query{Text: "mil", Sources: []Source{Notifications}, Pid: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].ID != "n1" {
+		t.Fatalf("results = %+v, want alice's notification only", results)
+	}
+	snippet := string(results[0].Snippet)
+	if !strings.Contains(snippet, "<mark>milk</mark>") {
+		t.Errorf("snippet %q does not mark the match", snippet)
+	}
+	if strings.Contains(snippet, "<script>") || !strings.Contains(snippet, "&lt;script&gt;") {
+		t.Errorf("snippet %q is not escaped", snippet)
+	}
+}
+
+func TestSearchSyntaxIsLiteral(t *testing.T) {
+	s := testService(t)
+	ctx := context.Background()
+
+	for _, text := range []string{
+		`"oat`, `oat"`, "oat*", "*", "-milk", "oat -milk", "milk NOT oat", "oat OR", "AND",
+		"NEAR(oat milk)", "NEAR(oat milk, 1)", "body:milk", "^milk", "(oat", "script>",
+	} {
+		if _, err := s.Search(ctx, Query{Text: text, Sources: []Source{Notifications, People}, Pid: "alice"}); err != nil {
+			t.Errorf("Search(%q) = %v", text, err)
+		}
+	}
+
+	// Operators are words like any other: NOT is not in the notification, so nothing matches.
+	results, err := s.Search(ctx, Query{Text: "milk NOT oat", Sources: []Source{Notifications}, Pid: "alice"})
+	if err != nil || len(results) != 0 {
+		t.Errorf("Search(milk NOT oat) = %+v, %v; want nothing", results, err)
+	}
+	results, err = s.Search(ctx, Query{Text: `"oat" milk`, Sources: []Source{Notifications}, Pid: "alice"})
+	if err != nil || len(results) != 1 {
+		t.Errorf(`Search("oat" milk) = %+v, %v; want alice's notification`, results, err)
+	}
+	if results, err := s.Search(ctx, Query{Text: "", Sources: []Source{Notifications}, Pid: "alice"}); err != nil || results != nil {
+		t.Errorf("empty search = %+v, %v; want nothing", results, err)
+	}
+}
+
+func TestSearchPeople(t *testing.T) {
+	s := testService(t)
+	results, err := s.Search(context.Background(), Query{Text: "ali", Sources: []Source{People}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].ID != "alice" || results[0].Title != "@alice" {
+		t.Errorf("results = %+v, want alice", results)
+	}
+}
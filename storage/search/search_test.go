@@ -0,0 +1,59 @@
+package search
+
+import (
+	"testing"
+)
+
+func TestMatchExpr(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"", ""},
+		{"   ", ""},
+		{`"*-()^:`, ""},
+		{"milk", `"milk"*`},
+		{"oat milk", `"oat" "milk"*`},
+		{`say "hi"`, `"say" "hi"*`},
+		{`"unterminated`, `"unterminated"*`},
+		{"mil*", `"mil"*`},
+		{"* milk", `"milk"*`},
+		{"-milk", `"milk"*`},
+		{"milk -oat", `"milk" "oat"*`},
+		{"milk NOT oat", `"milk" "NOT" "oat"*`},
+		{"milk OR oat", `"milk" "OR" "oat"*`},
+		{"NEAR(oat milk, 2)", `"NEAR" "oat" "milk" "2"*`},
+		{"title:milk", `"title" "milk"*`},
+		{"^milk", `"milk"*`},
+		{"don't", `"don" "t"*`},
+		{"pão de açúcar", `"pão" "de" "açúcar"*`},
+		{"snake_case", `"snake_case"*`},
+	}
+	for _, tt := range tests {
+		if got := MatchExpr(tt.text); got != tt.want {
+			t.Errorf("MatchExpr(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	tests := []struct {
+		snippet string
+		want    string
+	}{
+		{"plain text", "plain text"},
+		{"buy \x02milk\x03 today", "buy <mark>milk</mark> today"},
+		{
+			"\x02<script>\x03alert(1)</script>",
+			"<mark>&lt;script&gt;</mark>alert(1)&lt;/script&gt;",
+		},
+		{`<img src=x onerror="alert(1)">`, `&lt;img src=x onerror=&#34;alert(1)&#34;&gt;`},
+		{"Tom &amp; \x02Jerry\x03's", "Tom &amp;amp; <mark>Jerry</mark>&#39;s"},
+		{"<mark>not ours</mark>", "&lt;mark&gt;not ours&lt;/mark&gt;"},
+	}
+	for _, tt := range tests {
+		if got := string(highlight(tt.snippet)); got != tt.want {
+			t.Errorf("highlight(%q) = %q, want %q", tt.snippet, got, tt.want)
+		}
+	}
+}
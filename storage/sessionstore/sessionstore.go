@@ -0,0 +1,76 @@
+package sessionstore
+
+import (
+	"context"
+	"time"
+
+	"github.com/avalonbits/echo-template-service/storage"
+	"github.com/avalonbits/echo-template-service/storage/datastore"
+)
+
+// Store keeps scs sessions in the sessions table through storage.DB, so it works with every
+// backend storage supports and writes go through the same single writer as everything else.
+// Expired sessions are purged by the janitor.
+type Store struct {
+	db *storage.DB[datastore.Queries]
+}
+
+func New(db *storage.DB[datastore.Queries]) *Store {
+	return &Store{db: db}
+}
+
+func (s *Store) FindCtx(ctx context.Context, token string) ([]byte, bool, error) {
+	var data []byte
+	err := s.db.Read(ctx, func(queries *datastore.Queries) error {
+		var err error
+		data, err = queries.FindSession(ctx, datastore.FindSessionParams{
+			Token:  token,
+			Expiry: Expiry(time.Now()),
+		})
+		return err
+	})
+	if storage.NoRows(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return data, true, nil
+}
+
+func (s *Store) CommitCtx(ctx context.Context, token string, b []byte, expiry time.Time) error {
+	return s.db.Retry(ctx, func() error {
+		return s.db.Write(ctx, func(queries *datastore.Queries) error {
+			return queries.CommitSession(ctx, datastore.CommitSessionParams{
+				Token:  token,
+				Data:   b,
+				Expiry: Expiry(expiry),
+			})
+		})
+	})
+}
+
+func (s *Store) DeleteCtx(ctx context.Context, token string) error {
+	return s.db.Retry(ctx, func() error {
+		return s.db.Write(ctx, func(queries *datastore.Queries) error {
+			return queries.DeleteSession(ctx, token)
+		})
+	})
+}
+
+func (s *Store) Find(token string) ([]byte, bool, error) {
+	return s.FindCtx(context.Background(), token)
+}
+
+func (s *Store) Commit(token string, b []byte, expiry time.Time) error {
+	return s.CommitCtx(context.Background(), token, b, expiry)
+}
+
+func (s *Store) Delete(token string) error {
+	return s.DeleteCtx(context.Background(), token)
+}
+
+// Expiry converts t to the format of the sessions.expiry column: fractional unix seconds.
+func Expiry(t time.Time) float64 {
+	return float64(t.UnixNano()) / float64(time.Second)
+}
@@ -6,9 +6,14 @@ import (
 	"embed"
 	"errors"
 	"fmt"
+	"os"
+	"path"
+	"path/filepath"
 	"sync"
+	"time"
 
-	"github.com/pressly/goose/v3"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -20,95 +25,243 @@ type DBTX interface {
 	QueryRowContext(context.Context, string, ...any) *sql.Row
 }
 
+type Dialect string
+
+const (
+	SQLite   Dialect = "sqlite"
+	Postgres Dialect = "postgres"
+)
+
+// migrationsDir is where the migrations for the dialect live in the migrations FS.
+func (d Dialect) migrationsDir() string {
+	return path.Join("migrations", string(d))
+}
+
 type DB[Queries any] struct {
 	factory func(tx DBTX) *Queries
+	dialect Dialect
 	rddb    *sql.DB
 
+	// mu serializes writers. It is only set for SQLite, which supports a single writer at a time.
 	mu   *sync.Mutex
 	wrdb *sql.DB
+
+	replicator *replicator
+	metrics    metrics
+	tracer     tracer
+
+	// tempDir is removed on Close. Only set by TestDB.
+	tempDir string
+}
+
+// Option configures optional features of the database returned by GetDB.
+type Option func(*options)
+
+type options struct {
+	replica          Replica
+	replicaInterval  time.Duration
+	replicaRetention time.Duration
+	meter            metric.Meter
+	tracer           trace.Tracer
+	slowQuery        time.Duration
+	requireMigrated  bool
+	skipMigrations   bool
 }
 
+// Readers set query_only so writing in a Read transaction fails instead of silently racing
+// the writer.
 const (
-	readDSN  = "%s?_journal=wal&_sync=1&_busy_timeout=5000&_cache_size=10000&_txlock=deferred"
+	readDSN  = "%s?_journal=wal&_sync=1&_busy_timeout=5000&_cache_size=10000&_txlock=deferred&_query_only=1"
 	writeDSN = "%s?_journal=wal&_sync=1&_busy_timeout=5000&_cache_size=10000&_txlock=immediate"
 )
 
+// TestDB returns a migrated database in a temporary directory that is removed when it is closed.
+// It is opened like any SQLite database GetDB opens, so Read transactions are read-only and
+// writes go through a single connection, as they do in production. That is why it is a file
+// rather than an in-memory database: reads use their own connections, opened with readDSN, and
+// each connection to ":memory:" would get a database of its own, empty and without the writes.
 func TestDB[Queries any](migrations embed.FS, factory func(tx DBTX) *Queries) *DB[Queries] {
-	db, err := sql.Open("sqlite3", fmt.Sprintf(writeDSN, ":memory:"))
+	dir, err := os.MkdirTemp("", "testdb")
 	if err != nil {
 		panic(err)
 	}
-	goose.SetBaseFS(migrations)
-	if err := goose.SetDialect("sqlite"); err != nil {
-		db.Close()
+	db, err := getSQLiteDB(filepath.Join(dir, "test.db"), migrations, factory, options{})
+	if err != nil {
+		os.RemoveAll(dir)
 		panic(err)
 	}
+	db.tempDir = dir
+	return db
+}
 
-	if err := goose.Up(db, "migrations"); err != nil {
-		db.Close()
-		panic(err)
+// GetDB opens the database at dbURL and brings it up to date with migrations, unless the
+// RequireMigrated option is given. Postgres is used when dbURL is a postgres:// or postgresql://
+// URL, otherwise dbURL is a SQLite file name.
+func GetDB[Queries any](
+	dbURL string, migrations embed.FS, factory func(tx DBTX) *Queries, opts ...Option,
+) (*DB[Queries], error) {
+	o := options{
+		replicaInterval:  time.Second,
+		replicaRetention: 72 * time.Hour,
+	}
+	for _, opt := range opts {
+		opt(&o)
 	}
-	return &DB[Queries]{factory: factory, rddb: db, mu: &sync.Mutex{}, wrdb: db}
+
+	if isPostgres(dbURL) {
+		return getPostgresDB(dbURL, migrations, factory, o)
+	}
+	return getSQLiteDB(dbURL, migrations, factory, o)
 }
 
-func GetDB[Queries any](
-	dbName string, migrations embed.FS, factory func(tx DBTX) *Queries) (*DB[Queries], error) {
-	db, err := sql.Open("sqlite3", fmt.Sprintf(writeDSN, dbName))
+func getSQLiteDB[Queries any](
+	dbName string, migrations embed.FS, factory func(tx DBTX) *Queries, o options,
+) (*DB[Queries], error) {
+	m, err := newMetrics(o.meter)
 	if err != nil {
 		return nil, err
 	}
 
-	goose.SetBaseFS(migrations)
-	if err := goose.SetDialect("sqlite"); err != nil {
-		db.Close()
+	mdb, err := sql.Open("sqlite3", fmt.Sprintf(writeDSN, dbName))
+	if err != nil {
 		return nil, err
 	}
 
-	if err := goose.Up(db, "migrations"); err != nil {
-		db.Close()
+	if err := migrate(mdb, SQLite, migrations, o); err != nil {
+		mdb.Close()
 		return nil, err
 	}
-	db.Close()
+	mdb.Close()
+
+	// The replicator needs to control when the WAL is checkpointed.
+	driver := "sqlite3"
+	if o.replica != nil {
+		driver = replicatedDriver
+	}
 
-	wrdb, err := sql.Open("sqlite3", fmt.Sprintf(writeDSN, dbName))
+	wrdb, err := sql.Open(driver, fmt.Sprintf(writeDSN, dbName))
 	if err != nil {
 		return nil, err
 	}
 	wrdb.SetMaxOpenConns(1)
 
-	rddb, err := sql.Open("sqlite3", fmt.Sprintf(readDSN, dbName))
+	rddb, err := sql.Open(driver, fmt.Sprintf(readDSN, dbName))
 	if err != nil {
 		wrdb.Close()
 		return nil, err
 	}
-	return &DB[Queries]{factory: factory, rddb: rddb, mu: &sync.Mutex{}, wrdb: wrdb}, nil
+
+	db := &DB[Queries]{
+		factory: factory,
+		dialect: SQLite,
+		rddb:    rddb,
+		mu:      &sync.Mutex{},
+		wrdb:    wrdb,
+		metrics: m,
+		tracer:  newTracer(SQLite, m, o),
+	}
+	if o.replica != nil {
+		db.replicator = newReplicator(db.mu, wrdb, dbName, o)
+		db.replicator.start()
+	}
+	return db, nil
 }
 
 func (db *DB[Queries]) RDBMS() *sql.DB {
 	return db.wrdb
 }
 
+func (db *DB[Queries]) Dialect() Dialect {
+	return db.dialect
+}
+
 func (db *DB[Queries]) Close() error {
-	return errors.Join(db.rddb.Close(), db.wrdb.Close())
+	var err error
+	if db.replicator != nil {
+		err = db.replicator.close()
+	}
+	if db.rddb == db.wrdb {
+		err = errors.Join(err, db.wrdb.Close())
+	} else {
+		err = errors.Join(err, db.rddb.Close(), db.wrdb.Close())
+	}
+	if db.tempDir != "" {
+		err = errors.Join(err, os.RemoveAll(db.tempDir))
+	}
+	return err
 }
 
+// Read runs f in a read-only transaction. Writing in it fails with ErrReadOnly. When ctx comes from
+// WriteTx, f runs in that write transaction instead so it sees its changes.
 func (db *DB[Queries]) Read(ctx context.Context, f func(queries *Queries) error) error {
-	return db.transaction(ctx, db.rddb, f)
+	if tx := db.activeTx(ctx); tx != nil {
+		return f(db.factory(tx.dbtx))
+	}
+	return classify(db.transaction(ctx, db.rddb, readOnly, func(_ context.Context, tx DBTX) error {
+		return f(db.factory(tx))
+	}))
 }
 
+// Write runs f in a write transaction. It fails with ErrBusy when concurrent transactions got in
+// the way; wrap it in Retry to try again when f is safe to run more than once.
 func (db *DB[Queries]) Write(ctx context.Context, f func(queries *Queries) error) error {
-	db.mu.Lock()
-	defer db.mu.Unlock()
-	return db.transaction(ctx, db.wrdb, f)
+	return db.WriteTx(ctx, func(_ context.Context, queries *Queries) error {
+		return f(queries)
+	})
 }
 
-func (db *DB[Queries]) transaction(ctx context.Context, rdbms *sql.DB, f func(queries *Queries) error) error {
-	tx, err := rdbms.BeginTx(ctx, nil)
+// WriteTx is like Write, but f also gets a context carrying the transaction. Calling Write or
+// WriteTx with that context nests in a savepoint of the same transaction rather than starting a
+// new one, so transactional helpers can be composed: if the nested f fails only its changes are
+// rolled back.
+func (db *DB[Queries]) WriteTx(ctx context.Context, f func(ctx context.Context, queries *Queries) error) error {
+	if tx := db.activeTx(ctx); tx != nil {
+		return classify(tx.savepoint(ctx, func() error {
+			return f(ctx, db.factory(tx.dbtx))
+		}))
+	}
+
+	return db.write(ctx, func(ctx context.Context, tx DBTX) error {
+		return f(ctx, db.factory(tx))
+	})
+}
+
+// WriteRaw is like Write, but f gets the transaction itself, for statements that have no query in
+// Queries, like schema that depends on how the binary was built.
+func (db *DB[Queries]) WriteRaw(ctx context.Context, f func(ctx context.Context, tx DBTX) error) error {
+	return db.write(ctx, f)
+}
+
+func (db *DB[Queries]) write(ctx context.Context, f func(ctx context.Context, tx DBTX) error) error {
+	if db.mu != nil {
+		db.mu.Lock()
+		defer db.mu.Unlock()
+	}
+	return classify(db.transaction(ctx, db.wrdb, nil, f))
+}
+
+var readOnly = &sql.TxOptions{ReadOnly: true}
+
+func (db *DB[Queries]) transaction(
+	ctx context.Context,
+	rdbms *sql.DB,
+	opts *sql.TxOptions,
+	f func(ctx context.Context, tx DBTX) error,
+) error {
+	tx, err := rdbms.BeginTx(ctx, opts)
 	if err != nil {
 		return fmt.Errorf("error creating transaction: %w", err)
 	}
 
-	if err := f(db.factory(tx)); err != nil {
+	var dbtx DBTX = tx
+	if db.dialect == Postgres {
+		dbtx = rebinder{tx: tx}
+	}
+	dbtx = db.tracer.wrap(dbtx)
+
+	active := &activeTx{db: db, dbtx: dbtx}
+	defer active.end()
+	if err := f(context.WithValue(ctx, txKey{}, active), dbtx); err != nil {
 		rbErr := tx.Rollback()
 		if rbErr != nil {
 			err = errors.Join(err, rbErr)
@@ -0,0 +1,124 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/avalonbits/echo-template-service/storage"
+	"github.com/avalonbits/echo-template-service/storage/datastore"
+)
+
+func testDB(t *testing.T) *storage.DB[datastore.Queries] {
+	t.Helper()
+	db := storage.TestDB(datastore.Migrations, datastore.Factory)
+	t.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			t.Error(err)
+		}
+	})
+	return db
+}
+
+func createUser(ctx context.Context, queries *datastore.Queries, id string) error {
+	return queries.CreateUser(ctx, datastore.CreateUserParams{
+		ID:        id,
+		Handle:    id,
+		CreatedAt: "2024-01-01T00:00:00Z",
+		Password:  []byte("password"),
+		Salt:      []byte("salt"),
+	})
+}
+
+func registered(t *testing.T, db *storage.DB[datastore.Queries], id string) bool {
+	t.Helper()
+	ctx := context.Background()
+	var found bool
+	err := db.Read(ctx, func(queries *datastore.Queries) error {
+		_, err := queries.GetPerson(ctx, id)
+		if storage.NoRows(err) {
+			return nil
+		}
+		found = err == nil
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return found
+}
+
+func TestReadIsReadOnly(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+
+	err := db.Read(ctx, func(queries *datastore.Queries) error {
+		return createUser(ctx, queries, "alice")
+	})
+	if !errors.Is(err, storage.ErrReadOnly) {
+		t.Fatalf("writing in Read = %v, want ErrReadOnly", err)
+	}
+	if registered(t, db, "alice") {
+		t.Fatal("the write in Read was committed")
+	}
+}
+
+func TestNestedWriteRollsBackToSavepoint(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+	errNested := errors.New("nested failure")
+
+	err := db.WriteTx(ctx, func(ctx context.Context, queries *datastore.Queries) error {
+		if err := createUser(ctx, queries, "alice"); err != nil {
+			return err
+		}
+		err := db.Write(ctx, func(queries *datastore.Queries) error {
+			if err := createUser(ctx, queries, "bob"); err != nil {
+				return err
+			}
+			return errNested
+		})
+		if !errors.Is(err, errNested) {
+			t.Errorf("nested Write = %v, want %v", err, errNested)
+		}
+
+		// Reads with the transaction's context see its changes.
+		return db.Read(ctx, func(queries *datastore.Queries) error {
+			_, err := queries.GetPerson(ctx, "alice")
+			return err
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !registered(t, db, "alice") {
+		t.Error("alice was rolled back with the nested write")
+	}
+	if registered(t, db, "bob") {
+		t.Error("bob was committed even though the nested write failed")
+	}
+}
+
+func TestRetryInTransactionRunsOnce(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+
+	attempts := 0
+	err := db.WriteTx(ctx, func(ctx context.Context, queries *datastore.Queries) error {
+		err := db.Retry(ctx, func() error {
+			attempts++
+			return storage.ErrBusy
+		})
+		if !errors.Is(err, storage.ErrBusy) {
+			t.Errorf("Retry = %v, want ErrBusy", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 1 {
+		t.Errorf("f ran %d times in a transaction, want 1", attempts)
+	}
+}
@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// WithTracer starts a span with tracer for every query. Without it queries are not traced.
+func WithTracer(tracer trace.Tracer) Option {
+	return func(o *options) {
+		o.tracer = tracer
+	}
+}
+
+// WithSlowQuery logs queries that take longer than threshold. Query parameters are never logged,
+// only their types.
+func WithSlowQuery(threshold time.Duration) Option {
+	return func(o *options) {
+		o.slowQuery = threshold
+	}
+}
+
+// tracer instruments the queries of a DB.
+type tracer struct {
+	tracer    trace.Tracer
+	metrics   metrics
+	system    attribute.KeyValue
+	slowQuery time.Duration
+}
+
+func newTracer(dialect Dialect, metrics metrics, o options) tracer {
+	t := tracer{
+		tracer:    o.tracer,
+		metrics:   metrics,
+		system:    attribute.String("db.system", string(dialect)),
+		slowQuery: o.slowQuery,
+	}
+	if t.tracer == nil {
+		t.tracer = noop.NewTracerProvider().Tracer("storage")
+	}
+	return t
+}
+
+// wrap returns a DBTX that instruments every query run on tx.
+func (t tracer) wrap(tx DBTX) DBTX {
+	return traced{tx: tx, t: t}
+}
+
+func (t tracer) start(ctx context.Context, query string) (context.Context, func(err error, args []any)) {
+	name := queryName(query)
+	ctx, span := t.tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(t.system, attribute.String("db.operation.name", name)),
+	)
+
+	start := time.Now()
+	return ctx, func(err error, args []any) {
+		elapsed := time.Since(start)
+		if err != nil && !NoRows(err) {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+
+		t.metrics.query(ctx, t.system, name, elapsed)
+		if t.slowQuery > 0 && elapsed >= t.slowQuery {
+			slog.WarnContext(ctx, "slow query",
+				"query", name,
+				"duration", elapsed,
+				"args", redact(args),
+			)
+		}
+	}
+}
+
+type traced struct {
+	tx DBTX
+	t  tracer
+}
+
+func (tr traced) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	ctx, end := tr.t.start(ctx, query)
+	res, err := tr.tx.ExecContext(ctx, query, args...)
+	end(err, args)
+	return res, err
+}
+
+func (tr traced) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	ctx, end := tr.t.start(ctx, query)
+	stmt, err := tr.tx.PrepareContext(ctx, query)
+	end(err, nil)
+	return stmt, err
+}
+
+// QueryContext only measures starting the query: sqlc code needs the *sql.Rows itself, so there is
+// no hook to end the span when the rows are closed. Time spent reading the rows is not in the span,
+// the histogram or the slow query log.
+func (tr traced) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	ctx, end := tr.t.start(ctx, query)
+	rows, err := tr.tx.QueryContext(ctx, query, args...)
+	end(err, args)
+	return rows, err
+}
+
+func (tr traced) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	ctx, end := tr.t.start(ctx, query)
+	row := tr.tx.QueryRowContext(ctx, query, args...)
+	end(row.Err(), args)
+	return row
+}
+
+// queryNames caches the names of queries, which are a fixed set.
+var queryNames sync.Map
+
+// queryName returns the name sqlc gives the query in its "-- name: GetPerson :one" comment, or
+// the SQL command for queries sqlc did not generate.
+func queryName(query string) string {
+	if name, ok := queryNames.Load(query); ok {
+		return name.(string)
+	}
+
+	name := "query"
+	if rest, ok := strings.CutPrefix(strings.TrimSpace(query), "-- name: "); ok {
+		if fields := strings.Fields(rest); len(fields) > 0 {
+			name = fields[0]
+		}
+	} else if fields := strings.Fields(query); len(fields) > 0 {
+		name = strings.ToUpper(fields[0])
+	}
+	queryNames.Store(query, name)
+	return name
+}
+
+// redact replaces query parameters with their types, so logs never contain user data.
+func redact(args []any) []string {
+	redacted := make([]string, len(args))
+	for i, arg := range args {
+		redacted[i] = fmt.Sprintf("%T", arg)
+	}
+	return redacted
+}
@@ -0,0 +1,215 @@
+package trash
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/avalonbits/echo-template-service/storage"
+	"github.com/avalonbits/echo-template-service/storage/datastore"
+	"github.com/avalonbits/echo-template-service/storage/jobs"
+)
+
+const purgeJob = "trash.purge"
+
+var ErrNotFound = errors.New("item is not in the trash or can no longer be restored")
+
+// Kind is a kind of record that is soft deleted: its table has a nullable deleted_at column
+// holding an RFC3339 time, and every query outside of the Kind skips rows where it is set.
+// Adding a soft deletable table means adding the column, filtering the table's queries and
+// declaring a Kind with the queries below.
+type Kind struct {
+	Name string
+	// AdminOnly kinds are not owned by anyone, so only admins can see and restore them.
+	AdminOnly bool
+
+	// List returns the deleted records pid owns, most recently deleted first.
+	List func(ctx context.Context, queries *datastore.Queries, pid string, limit int64) ([]Item, error)
+	// Restore undeletes the record id owned by pid if it was deleted after deletedAfter, reporting
+	// whether it did.
+	Restore func(ctx context.Context, queries *datastore.Queries, pid, id string, deletedAfter sql.NullString) (bool, error)
+	// Purge hard deletes every record deleted before deletedBefore, along with what belongs to it.
+	Purge func(ctx context.Context, queries *datastore.Queries, deletedBefore sql.NullString) (int64, error)
+}
+
+// People is the trash of deleted accounts. A deleted person can no longer sign in, so only admins
+// can restore them.
+var People = Kind{
+	Name:      "person",
+	AdminOnly: true,
+	List: func(ctx context.Context, queries *datastore.Queries, _ string, limit int64) ([]Item, error) {
+		rows, err := queries.ListDeletedPeople(ctx, limit)
+		if err != nil {
+			return nil, err
+		}
+		items := make([]Item, 0, len(rows))
+		for _, row := range rows {
+			title := "@" + row.Handle
+			if row.DisplayName.String != "" {
+				title = row.DisplayName.String + " (@" + row.Handle + ")"
+			}
+			deleted, _ := time.Parse(time.RFC3339, row.DeletedAt.String)
+			items = append(items, Item{ID: row.ID, Title: title, DeletedAt: deleted})
+		}
+		return items, nil
+	},
+	Restore: func(ctx context.Context, queries *datastore.Queries, _, id string, deletedAfter sql.NullString) (bool, error) {
+		n, err := queries.RestorePerson(ctx, datastore.RestorePersonParams{
+			ID:        id,
+			DeletedAt: deletedAfter,
+		})
+		return n > 0, err
+	},
+	Purge: func(ctx context.Context, queries *datastore.Queries, deletedBefore sql.NullString) (int64, error) {
+		// SQLite does not enforce foreign keys and RegistrationToken has none, so what people own
+		// is deleted explicitly.
+		for _, purge := range []func(context.Context, sql.NullString) (int64, error){
+			queries.PurgeDeletedPeopleNotifications,
+			queries.PurgeDeletedPeoplePreferences,
+			queries.PurgeDeletedPeopleWebhooks,
+			queries.PurgeDeletedPeopleTokens,
+		} {
+			if _, err := purge(ctx, deletedBefore); err != nil {
+				return 0, err
+			}
+		}
+		return queries.PurgeDeletedPeople(ctx, deletedBefore)
+	},
+}
+
+// Item is a deleted record.
+type Item struct {
+	Kind      string
+	ID        string
+	Title     string
+	DeletedAt time.Time
+	// PurgeAt is when the item is deleted for good and can no longer be restored.
+	PurgeAt time.Time
+}
+
+// Service lists and restores soft deleted records, and purges them once they have been in the
+// trash for longer than the retention period.
+type Service struct {
+	db        *storage.DB[datastore.Queries]
+	retention time.Duration
+	kinds     []Kind
+}
+
+// New registers the purge job with runner and schedules it to run every hour.
+func New(
+	db *storage.DB[datastore.Queries],
+	runner *jobs.Runner,
+	retention time.Duration,
+	kinds ...Kind,
+) (*Service, error) {
+	s := &Service{
+		db:        db,
+		retention: retention,
+		kinds:     kinds,
+	}
+	runner.Register(purgeJob, s.purge)
+	if err := runner.Schedule(purgeJob, "@hourly", purgeJob, nil); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Retention is how long records stay in the trash.
+func (s *Service) Retention() time.Duration {
+	return s.retention
+}
+
+// Items returns what pid can restore, most recently deleted first within each kind. Admins also
+// get the AdminOnly kinds.
+func (s *Service) Items(ctx context.Context, pid string, admin bool, limit int) ([]Item, error) {
+	var items []Item
+	err := s.db.Read(ctx, func(queries *datastore.Queries) error {
+		for _, kind := range s.kinds {
+			if kind.AdminOnly && !admin {
+				continue
+			}
+			list, err := kind.List(ctx, queries, pid, int64(limit))
+			if err != nil {
+				return err
+			}
+			for _, item := range list {
+				item.Kind = kind.Name
+				item.PurgeAt = item.DeletedAt.Add(s.retention)
+				items = append(items, item)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// Restore takes the record id of kind out of the trash. It returns ErrNotFound if pid cannot
+// restore it, including when it has been in the trash for longer than the retention period.
+func (s *Service) Restore(ctx context.Context, pid string, admin bool, kind, id string) error {
+	k, ok := s.kind(kind)
+	if !ok || (k.AdminOnly && !admin) {
+		return ErrNotFound
+	}
+
+	return s.db.Write(ctx, func(queries *datastore.Queries) error {
+		restored, err := k.Restore(ctx, queries, pid, id, s.cutoff(time.Now()))
+		if err != nil {
+			return err
+		}
+		if !restored {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
+// Purge hard deletes everything that has been in the trash for longer than the retention period
+// as of now. It returns how many records were deleted, per kind.
+func (s *Service) Purge(ctx context.Context, now time.Time) (map[string]int64, error) {
+	purged := map[string]int64{}
+	err := s.db.Write(ctx, func(queries *datastore.Queries) error {
+		for _, kind := range s.kinds {
+			n, err := kind.Purge(ctx, queries, s.cutoff(now))
+			if err != nil {
+				return err
+			}
+			purged[kind.Name] = n
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return purged, nil
+}
+
+func (s *Service) purge(ctx context.Context, _ jobs.Job) error {
+	purged, err := s.Purge(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+	for kind, n := range purged {
+		if n > 0 {
+			log.Printf("trash purged %d %s records", n, kind)
+		}
+	}
+	return nil
+}
+
+func (s *Service) kind(name string) (Kind, bool) {
+	for _, k := range s.kinds {
+		if k.Name == name {
+			return k, true
+		}
+	}
+	return Kind{}, false
+}
+
+func (s *Service) cutoff(now time.Time) sql.NullString {
+	return sql.NullString{String: now.UTC().Add(-s.retention).Format(time.RFC3339), Valid: true}
+}
@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+type txKey struct{}
+
+// activeTx is the write transaction stored in the context WriteTx passes to its closure.
+type activeTx struct {
+	db   any
+	dbtx DBTX
+
+	mu         sync.Mutex
+	done       bool
+	savepoints int
+}
+
+// activeTx returns the transaction in ctx, if it belongs to db and is still open.
+func (db *DB[Queries]) activeTx(ctx context.Context) *activeTx {
+	tx, ok := ctx.Value(txKey{}).(*activeTx)
+	if !ok || tx.db != any(db) {
+		return nil
+	}
+
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	if tx.done {
+		return nil
+	}
+	return tx
+}
+
+func (tx *activeTx) end() {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	tx.done = true
+}
+
+// savepoint runs f inside a savepoint, rolling back to it if f fails.
+func (tx *activeTx) savepoint(ctx context.Context, f func() error) error {
+	tx.mu.Lock()
+	tx.savepoints++
+	name := fmt.Sprintf("sp%d", tx.savepoints)
+	tx.mu.Unlock()
+
+	if _, err := tx.dbtx.ExecContext(ctx, "SAVEPOINT "+name); err != nil {
+		return fmt.Errorf("error creating savepoint: %w", err)
+	}
+	if err := f(); err != nil {
+		// Rolling back to a savepoint keeps it open in both SQLite and Postgres.
+		_, rbErr := tx.dbtx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+name)
+		if rbErr == nil {
+			_, rbErr = tx.dbtx.ExecContext(ctx, "RELEASE SAVEPOINT "+name)
+		}
+		return errors.Join(err, rbErr)
+	}
+	_, err := tx.dbtx.ExecContext(ctx, "RELEASE SAVEPOINT "+name)
+	return err
+}